@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/admin"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	log "github.com/sirupsen/logrus"
+)
+
+// startAdminServer serves the consumer's metrics and health checks. The
+// consumer is ready while the broker channel and the database are usable.
+func startAdminServer(cfg *configs.Config, dbService *services.DatabaseService, rmqService broker.Broker) (*admin.Server, *http.Server) {
+	if cfg.Server.Environment == "production" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	adminServer := admin.NewServer(map[string]admin.Check{
+		"database": dbService.HealthCheck,
+		"rabbitmq": func(context.Context) error {
+			return rmqService.HealthCheck()
+		},
+	})
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Consumer.AdminPort),
+		Handler:           adminServer.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		log.WithField("port", cfg.Consumer.AdminPort).Info("Admin server is running")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Fatal("Failed to start admin server")
+		}
+	}()
+
+	return adminServer, srv
+}
+
+// stopAdminServer shuts the admin server down once the consumer has stopped
+func stopAdminServer(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.WithError(err).Error("Failed to shut down admin server")
+	}
+}
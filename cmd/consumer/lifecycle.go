@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/webhook"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// openTicketStatuses are the statuses of tickets an event change applies to:
+// held or paid for, or failed but still retryable by the buyer
+var openTicketStatuses = []string{"pending", "confirmed", "payment_failed", "expired"}
+
+// processEventLifecycleMessage cascades an event cancellation or reschedule
+// from dws-event-service to the event's tickets. Every step is idempotent so
+// a partially processed message can be redelivered safely; a fully processed
+// one is recorded in the ledger and ignored.
+func (p *ticketProcessor) processEventLifecycleMessage(msg broker.Delivery) error {
+	legacyType := types.MessageTypeEventCancelled
+	if msg.RoutingKey == broker.RoutingKeyEventRescheduled {
+		legacyType = types.MessageTypeEventRescheduled
+	}
+	eventMsg, env, err := decodeMessage[types.EventLifecycleMessage](p.decoder, msg, legacyType)
+	if err != nil {
+		log.WithError(err).Error("Failed to decode event lifecycle message")
+		return err
+	}
+	if eventMsg.EventID == "" {
+		return &permanentError{err: fmt.Errorf("event lifecycle message without event_id")}
+	}
+
+	log.WithFields(log.Fields{
+		"event_id":    eventMsg.EventID,
+		"routing_key": msg.RoutingKey,
+	}).Info("Processing event lifecycle message")
+
+	ctx, cancel := context.WithTimeout(envelope.WithCorrelationID(context.Background(), env.CorrelationID), 2*time.Minute)
+	defer cancel()
+
+	processed, err := p.ledger.Processed(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	switch msg.RoutingKey {
+	case broker.RoutingKeyEventCancelled:
+		if processed {
+			log.WithField("event_id", eventMsg.EventID).Info("Event cancellation already processed, skipping")
+			return nil
+		}
+		return p.cancelEventTickets(ctx, env, eventMsg)
+	case broker.RoutingKeyEventRescheduled:
+		return p.rescheduleEventTickets(ctx, env, eventMsg, processed)
+	default:
+		return &permanentError{err: fmt.Errorf("unexpected routing key %s", msg.RoutingKey)}
+	}
+}
+
+// cancelEventTickets refunds confirmed tickets and cancels the others, so
+// failed payments can no longer be retried for the cancelled event. The
+// message is recorded once every ticket is done; each ticket's transition is
+// conditional on its status, so a cascade cut short is picked up where it
+// stopped.
+func (p *ticketProcessor) cancelEventTickets(ctx context.Context, env envelope.Envelope, eventMsg types.EventLifecycleMessage) error {
+	tickets, err := p.dbService.Client.Ticket.FindMany(
+		db.Ticket.EventID.Equals(eventMsg.EventID),
+		db.Ticket.Status.In(openTicketStatuses),
+	).Exec(ctx)
+
+	if err != nil {
+		return fmt.Errorf("failed to fetch tickets for cancelled event: %w", err)
+	}
+
+	for i := range tickets {
+		ticket := &tickets[i]
+		newStatus := "cancelled"
+
+		if ticket.Status == "confirmed" {
+			if paymentID, ok := ticket.PaymentID(); ok {
+				if _, err := p.payments.Refund(ctx, paymentID, 0); err != nil {
+					return fmt.Errorf("failed to refund ticket %s: %w", ticket.ID, err)
+				}
+			}
+			newStatus = "refunded"
+		}
+
+		// Notify first: if the transition below fails the message is
+		// redelivered, and notifications are deduplicated downstream
+		if err := p.rmqService.PublishTicketNotification(ctx, types.TicketNotificationMessage{
+			Type:      types.NotificationEventCancelled,
+			TicketID:  ticket.ID,
+			UserID:    ticket.UserID,
+			EventID:   ticket.EventID,
+			Status:    newStatus,
+			Reason:    eventMsg.Reason,
+			Timestamp: time.Now(),
+		}); err != nil {
+			return fmt.Errorf("failed to publish notification for ticket %s: %w", ticket.ID, err)
+		}
+
+		eventType := webhook.EventTicketCancelled
+		if newStatus == "refunded" {
+			eventType = webhook.EventTicketRefunded
+		}
+		cancelled := *ticket
+		cancelled.Status = newStatus
+		cancelled.UpdatedAt = time.Now()
+		reason := "event_cancelled"
+		cancelled.InnerTicket.FailureReason = &reason
+		if err := p.webhookService.Enqueue(ctx, eventType, &cancelled); err != nil {
+			return fmt.Errorf("failed to enqueue webhook for ticket %s: %w", ticket.ID, err)
+		}
+
+		result, err := p.dbService.Client.Ticket.FindMany(
+			db.Ticket.ID.Equals(ticket.ID),
+			db.Ticket.Status.Equals(ticket.Status),
+		).Update(
+			db.Ticket.Status.Set(newStatus),
+			db.Ticket.FailureReason.Set("event_cancelled"),
+		).Exec(ctx)
+
+		if err != nil {
+			return fmt.Errorf("failed to cancel ticket %s: %w", ticket.ID, err)
+		}
+		if result.Count == 0 {
+			// Changed concurrently (e.g. confirmed mid-way); retry the whole event
+			return fmt.Errorf("ticket %s changed while cancelling event %s", ticket.ID, eventMsg.EventID)
+		}
+
+		p.publishStatus(ctx, &cancelled, ticket.Status)
+	}
+
+	if err := p.ledger.Commit(ctx, env); err != nil && !errors.Is(err, services.ErrAlreadyProcessed) {
+		return fmt.Errorf("failed to record cancellation of event %s: %w", eventMsg.EventID, err)
+	}
+
+	log.WithFields(log.Fields{
+		"event_id": eventMsg.EventID,
+		"tickets":  len(tickets),
+	}).Info("Cancelled tickets for cancelled event")
+
+	return nil
+}
+
+// rescheduleEventTickets keeps tickets valid with the new date and notifies
+// holders. The dates are only updated by the first delivery of the message;
+// notifications are sent by every delivery until one succeeds, they are
+// deduplicated downstream.
+func (p *ticketProcessor) rescheduleEventTickets(ctx context.Context, env envelope.Envelope, eventMsg types.EventLifecycleMessage, processed bool) error {
+	if eventMsg.StartDate == nil {
+		return &permanentError{err: fmt.Errorf("event.rescheduled message for %s without start_date", eventMsg.EventID)}
+	}
+
+	// Bumping the sequence makes subscribed calendars replace the entry
+	if !processed {
+		reschedule := p.dbService.Client.Ticket.FindMany(
+			db.Ticket.EventID.Equals(eventMsg.EventID),
+		).Update(
+			db.Ticket.EventStartsAt.SetIfPresent(eventMsg.StartDate),
+			db.Ticket.EventEndsAt.SetIfPresent(eventMsg.EndDate),
+			db.Ticket.EventSequence.Increment(1),
+		).Tx()
+		if err := p.ledger.Commit(ctx, env, reschedule); err != nil && !errors.Is(err, services.ErrAlreadyProcessed) {
+			return fmt.Errorf("failed to update event dates on tickets: %w", err)
+		}
+	}
+
+	tickets, err := p.dbService.Client.Ticket.FindMany(
+		db.Ticket.EventID.Equals(eventMsg.EventID),
+		db.Ticket.Status.In(openTicketStatuses),
+	).Exec(ctx)
+
+	if err != nil {
+		return fmt.Errorf("failed to fetch tickets for rescheduled event: %w", err)
+	}
+
+	for _, ticket := range tickets {
+		if err := p.rmqService.PublishTicketNotification(ctx, types.TicketNotificationMessage{
+			Type:          types.NotificationEventRescheduled,
+			TicketID:      ticket.ID,
+			UserID:        ticket.UserID,
+			EventID:       ticket.EventID,
+			Status:        ticket.Status,
+			Reason:        eventMsg.Reason,
+			EventStartsAt: eventMsg.StartDate,
+			Timestamp:     time.Now(),
+		}); err != nil {
+			return fmt.Errorf("failed to publish notification for ticket %s: %w", ticket.ID, err)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"event_id":   eventMsg.EventID,
+		"tickets":    len(tickets),
+		"start_date": eventMsg.StartDate,
+	}).Info("Rescheduled tickets for event")
+
+	return nil
+}
@@ -3,20 +3,31 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/oskargbc/dws-ticket-service/configs"
-	"github.com/oskargbc/dws-ticket-service/internal/pkg/rabbitmq"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/admin"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/metrics"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/notifications"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/payment"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/webhook"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/workerpool"
 	"github.com/oskargbc/dws-ticket-service/internal/services"
 	"github.com/oskargbc/dws-ticket-service/internal/types"
 	"github.com/oskargbc/dws-ticket-service/prisma/db"
 	log "github.com/sirupsen/logrus"
-	"github.com/streadway/amqp"
 )
 
+// serviceName labels metrics recorded by the consumer
+const serviceName = "dws-ticket-service-consumer"
+
 func main() {
 	// Configure logging
 	log.SetFormatter(&log.JSONFormatter{})
@@ -43,29 +54,96 @@ func main() {
 	}()
 
 	// Initialize RabbitMQ
-	rmqService, err := rabbitmq.GetRabbitMQServiceInstance(cfg)
+	rmqService, err := services.NewBroker(cfg)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to initialize RabbitMQ service")
 	}
+	rmqService.SetServiceName(serviceName)
 	defer func() {
 		if err := rmqService.Close(); err != nil {
 			log.WithError(err).Error("Failed to close RabbitMQ connection")
 		}
 	}()
 
-	// Start consuming messages
-	if err := consumeTicketMessages(cfg, dbService, rmqService); err != nil {
+	// Initialize payment provider
+	paymentProvider, err := payment.NewProvider(cfg)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize payment provider")
+	}
+
+	processor := &ticketProcessor{
+		cfg:                 cfg,
+		dbService:           dbService,
+		rmqService:          rmqService,
+		invoiceService:      services.NewInvoiceService(cfg, dbService),
+		notificationService: services.NewNotificationService(cfg, dbService, notifications.NewSMTPSender(cfg.Notifications.SMTP)),
+		webhookService:      services.NewWebhookService(cfg, dbService),
+		ledger:              services.NewMessageLedgerService(cfg, dbService),
+		payments:            paymentProvider,
+		decoder:             newMessageDecoder(),
+	}
+
+	// Serve metrics and health checks until the consumer has stopped
+	adminServer, adminSrv := startAdminServer(cfg, dbService, rmqService)
+	defer stopAdminServer(adminSrv)
+
+	// Start the pending ticket sweeper and the processed message cleanup
+	sweeperCtx, stopSweeper := context.WithCancel(context.Background())
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		if cfg.Sweeper.Enabled {
+			processor.runSweeper(sweeperCtx)
+		}
+	}()
+	ledgerDone := make(chan struct{})
+	go func() {
+		defer close(ledgerDone)
+		processor.ledger.Run(sweeperCtx)
+	}()
+
+	// Start consuming messages; returns once in-flight messages are drained
+	if err := consumeTicketMessages(rmqService, processor, adminServer); err != nil {
 		log.WithError(err).Fatal("Failed to start consumer")
 	}
+
+	// The deferred closes of the broker and database run after this
+	stopSweeper()
+	<-sweeperDone
+	<-ledgerDone
+	log.Info("Consumer stopped")
 }
 
-func consumeTicketMessages(cfg *configs.Config, dbService *services.DatabaseService, rmqService *rabbitmq.RabbitMQService) error {
-	// Get channel from RabbitMQ service
+// ticketProcessor holds the dependencies needed to process ticket messages
+type ticketProcessor struct {
+	cfg                 *configs.Config
+	dbService           *services.DatabaseService
+	rmqService          broker.Broker
+	invoiceService      *services.InvoiceService
+	notificationService *services.NotificationService
+	webhookService      *services.WebhookService
+	ledger              *services.MessageLedgerService
+	payments            payment.Provider
+	decoder             *envelope.Decoder
+}
+
+func consumeTicketMessages(rmqService broker.Broker, processor *ticketProcessor, adminServer *admin.Server) error {
+	// Get channels from RabbitMQ service
 	msgs, err := rmqService.ConsumeTicketPurchased()
 	if err != nil {
 		return err
 	}
 
+	lifecycleMsgs, err := rmqService.ConsumeEventLifecycle()
+	if err != nil {
+		return err
+	}
+
+	notificationMsgs, err := rmqService.ConsumeTicketNotifications()
+	if err != nil {
+		return err
+	}
+
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -73,32 +151,178 @@ func consumeTicketMessages(cfg *configs.Config, dbService *services.DatabaseServ
 	log.Info("Consumer started, waiting for messages...")
 
 	// Process messages
-	go func() {
-		for msg := range msgs {
-			if err := processTicketMessage(msg, dbService); err != nil {
-				log.WithError(err).Error("Failed to process message")
-				if nackErr := msg.Nack(false, true); nackErr != nil {
-					log.WithError(nackErr).Error("Failed to nack message")
-				}
-			} else {
-				if ackErr := msg.Ack(false); ackErr != nil {
-					log.WithError(ackErr).Error("Failed to ack message")
-				}
-			}
-		}
-	}()
+	abortCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	var workers sync.WaitGroup
+	queues := processor.cfg.RabbitMQ.Queue
+	for _, c := range []struct {
+		queue   string
+		msgs    <-chan broker.Delivery
+		process func(broker.Delivery) error
+	}{
+		{queues.Purchased, msgs, processor.processTicketMessage},
+		{queues.EventLifecycle, lifecycleMsgs, processor.processEventLifecycleMessage},
+		{queues.Notification, notificationMsgs, processor.processNotificationMessage},
+	} {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			handleDeliveries(abortCtx, rmqService, &processor.cfg.Consumer, c.queue, c.msgs, c.process)
+		}()
+	}
 
 	// Wait for shutdown signal
 	<-sigChan
 	log.Info("Shutting down consumer...")
+	adminServer.SetDraining()
+	drain(rmqService, &workers, abort, time.Duration(processor.cfg.Consumer.ShutdownTimeoutSeconds)*time.Second)
 	return nil
 }
 
-func processTicketMessage(msg amqp.Delivery, dbService *services.DatabaseService) error {
-	// Parse message
-	var ticketMsg types.TicketMessage
-	if err := json.Unmarshal(msg.Body, &ticketMsg); err != nil {
-		log.WithError(err).Error("Failed to unmarshal message")
+// drain stops new deliveries and waits for the workers to finish the
+// messages they already received. When the deadline passes, messages that
+// haven't been started are requeued and only the ones being processed are
+// waited for, so no payment is interrupted half-way.
+func drain(rmqService broker.Broker, workers *sync.WaitGroup, abort context.CancelFunc, timeout time.Duration) {
+	if err := rmqService.CancelConsumers(); err != nil {
+		log.WithError(err).Warn("Failed to cancel consumers, unacked messages are redelivered after the connection closes")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info("Drained in-flight messages")
+	case <-time.After(timeout):
+		log.WithField("timeout", timeout).Warn("Drain deadline reached, requeueing messages that were not started")
+		abort()
+		<-done
+	}
+}
+
+// handleDeliveries processes deliveries on a pool of workers, acking on
+// success. Deliveries about the same ticket (or event) are processed one at a
+// time in order. Transient failures are retried with backoff through the
+// retry queues and dead-lettered once the retries are used up; permanent
+// failures are dead-lettered right away.
+func handleDeliveries(abortCtx context.Context, rmqService broker.Broker, cfg *configs.ConsumerConfig, queue string, msgs <-chan broker.Delivery, process func(broker.Delivery) error) {
+	// The prefetch count bounds the unacked deliveries, so a worker's buffer
+	// never fills up and one busy ticket can't hold up the others
+	pool := workerpool.New(cfg.Workers, cfg.PrefetchCount, deliveryKey, func(msg broker.Delivery) {
+		if abortCtx.Err() != nil {
+			// Shutting down past the drain deadline
+			if nackErr := msg.Nack(true); nackErr != nil {
+				log.WithError(nackErr).Error("Failed to requeue message")
+			}
+			return
+		}
+		handleDelivery(rmqService, queue, msg, process)
+	})
+
+	for msg := range msgs {
+		pool.Submit(msg)
+	}
+	pool.Close()
+}
+
+func handleDelivery(rmqService broker.Broker, queue string, msg broker.Delivery, process func(broker.Delivery) error) {
+	inFlight := metrics.ConsumerInFlight.WithLabelValues(queue, serviceName)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	err := process(msg)
+
+	outcome, handled := "success", "processed"
+	if err != nil {
+		outcome, handled = "failure", "failed"
+	}
+	metrics.ConsumerProcessingDuration.WithLabelValues(queue, outcome, serviceName).Observe(time.Since(start).Seconds())
+	metrics.ConsumerMessages.WithLabelValues(queue, handled, serviceName).Inc()
+	metrics.ConsumerLastMessage.WithLabelValues(queue, serviceName).SetToCurrentTime()
+
+	if err != nil {
+		settleFailed(rmqService, queue, msg, err)
+		return
+	}
+	if ackErr := msg.Ack(); ackErr != nil {
+		log.WithError(ackErr).Error("Failed to ack message")
+	}
+}
+
+// deliveryKey shards deliveries by ticket, or by event for event lifecycle
+// messages. Bodies that don't parse are spread by message ID.
+func deliveryKey(msg broker.Delivery) string {
+	var ids struct {
+		TicketID string `json:"ticket_id"`
+		EventID  string `json:"event_id"`
+	}
+	if err := json.Unmarshal(msg.Body, &ids); err == nil {
+		if ids.TicketID != "" {
+			return ids.TicketID
+		}
+		if ids.EventID != "" {
+			return ids.EventID
+		}
+	}
+	return msg.MessageID
+}
+
+// settleFailed moves a failed delivery to a retry queue or the dead-letter
+// queue and acks it. If that publish fails the delivery is requeued instead,
+// so it is never lost.
+func settleFailed(rmqService broker.Broker, queue string, msg broker.Delivery, err error) {
+	entry := log.WithError(err).WithFields(log.Fields{
+		"queue":       queue,
+		"message_id":  msg.MessageID,
+		"retry_count": broker.RetryCount(msg),
+	})
+
+	var permErr *permanentError
+	var moveErr error
+	action := "retry"
+	if errors.As(err, &permErr) {
+		// Retrying can't succeed
+		action = "dead_letter"
+		moveErr = rmqService.DeadLetter(queue, msg, err.Error())
+	} else {
+		var retried bool
+		if retried, moveErr = rmqService.Retry(queue, msg, err); moveErr == nil && !retried {
+			action = "dead_letter"
+			moveErr = rmqService.DeadLetter(queue, msg, "retries exhausted: "+err.Error())
+		}
+	}
+
+	if moveErr != nil {
+		entry.WithField("action", action).WithError(moveErr).Error("Failed to move failed message, requeueing it")
+		metrics.RabbitMQMessages.WithLabelValues(action, queue, "error", serviceName).Inc()
+		if nackErr := msg.Nack(true); nackErr != nil {
+			log.WithError(nackErr).Error("Failed to nack message")
+		}
+		return
+	}
+
+	metrics.RabbitMQMessages.WithLabelValues(action, queue, "success", serviceName).Inc()
+	if action == "dead_letter" {
+		entry.Error("Message dead-lettered")
+	} else {
+		metrics.ConsumerRetries.WithLabelValues(queue, serviceName).Inc()
+		entry.Warn("Failed to process message, scheduled retry")
+	}
+	if ackErr := msg.Ack(); ackErr != nil {
+		log.WithError(ackErr).Error("Failed to ack message")
+	}
+}
+
+func (p *ticketProcessor) processTicketMessage(msg broker.Delivery) error {
+	ticketMsg, env, err := decodeMessage[types.TicketMessage](p.decoder, msg, types.MessageTypeTicketPurchased)
+	if err != nil {
+		log.WithError(err).Error("Failed to decode message")
 		return err
 	}
 
@@ -109,37 +333,129 @@ func processTicketMessage(msg amqp.Delivery, dbService *services.DatabaseService
 		"quantity":  ticketMsg.Quantity,
 	}).Info("Processing ticket purchase message")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(envelope.WithCorrelationID(context.Background(), env.CorrelationID), 10*time.Second)
 	defer cancel()
 
 	// Get current ticket
-	ticket, err := dbService.Client.Ticket.FindUnique(
+	ticket, err := p.dbService.Client.Ticket.FindUnique(
 		db.Ticket.ID.Equals(ticketMsg.TicketID),
 	).Exec(ctx)
 
 	if err != nil {
 		log.WithError(err).Error("Failed to find ticket")
+		if errors.Is(err, db.ErrNotFound) {
+			return &permanentError{err: err}
+		}
 		return err
 	}
 
-	// If already confirmed, skip
-	if ticket.Status == "confirmed" {
+	switch ticket.Status {
+	case "confirmed":
+		// Only make sure the invoice and confirmation exist (a previous
+		// attempt may have failed after confirming)
 		log.WithField("ticket_id", ticketMsg.TicketID).Info("Ticket already confirmed, skipping")
+		return p.completeConfirmation(ctx, ticket)
+	case "pending":
+	case "payment_failed":
+		// A delivery failing after the decline committed may not have got
+		// the failure out
+		if ticketMsg.PaymentAttempt == ticket.PaymentAttempt {
+			reasonCode, _ := ticket.FailureReason()
+			return p.publishPaymentFailed(ctx, ticket, reasonCode, "")
+		}
+		return nil
+	default:
+		log.WithFields(log.Fields{
+			"ticket_id": ticketMsg.TicketID,
+			"status":    ticket.Status,
+		}).Info("Ticket is no longer pending, skipping")
 		return nil
 	}
 
-	// Simulate payment processing (in real app: call payment gateway)
-	time.Sleep(500 * time.Millisecond)
+	// A retry bumps the attempt; messages from earlier attempts are stale
+	if ticketMsg.PaymentAttempt != ticket.PaymentAttempt {
+		log.WithFields(log.Fields{
+			"ticket_id":       ticketMsg.TicketID,
+			"message_attempt": ticketMsg.PaymentAttempt,
+			"ticket_attempt":  ticket.PaymentAttempt,
+		}).Info("Stale payment attempt, skipping")
+		return nil
+	}
+
+	// Charge the buyer; the ticket is only confirmed once the payment is captured
+	result, err := p.chargeTicket(ctx, ticket, ticketMsg)
+	if err != nil {
+		log.WithError(err).Error("Payment processing failed")
+		return err
+	}
+
+	if result.Status != payment.StatusCaptured {
+		return p.failPayment(ctx, env, ticket, result)
+	}
 
-	// Update ticket status to confirmed
-	updatedTicket, err := dbService.Client.Ticket.FindUnique(
+	// If the ticket was cancelled or expired while the payment was in flight,
+	// the payment is recorded as owed back to the buyer in the same
+	// transaction, so the refund survives a crash and isn't lost to the ledger
+	// skipping redeliveries. The update runs first: once confirmed, the ticket
+	// no longer matches. A payment already recorded as owed isn't recorded
+	// again, so a refunded one isn't refunded twice.
+	owe := p.dbService.Client.Ticket.FindMany(
 		db.Ticket.ID.Equals(ticketMsg.TicketID),
+		db.Ticket.Status.In([]string{"cancelled", "expired"}),
+		db.Ticket.Or(
+			db.Ticket.OwedPaymentID.IsNull(),
+			db.Ticket.Not(db.Ticket.OwedPaymentID.Equals(result.ID)),
+		),
+	).Update(
+		db.Ticket.OwedPaymentID.Set(result.ID),
+		db.Ticket.RefundStatus.Set("due"),
+	).Tx()
+
+	// Update ticket status to confirmed, unless it left pending while the
+	// payment was in flight
+	confirm := p.dbService.Client.Ticket.FindMany(
+		db.Ticket.ID.Equals(ticketMsg.TicketID),
+		db.Ticket.Status.Equals("pending"),
 	).Update(
 		db.Ticket.Status.Set("confirmed"),
+		db.Ticket.PaymentID.Set(result.ID),
+		db.Ticket.ConfirmedAt.Set(time.Now()),
+	).Tx()
+
+	if err := p.ledger.Commit(ctx, env, owe, confirm); err != nil {
+		if errors.Is(err, services.ErrAlreadyProcessed) {
+			// The payment shares its idempotency key, so the buyer was charged
+			// once; the other delivery completes the confirmation
+			log.WithField("ticket_id", ticketMsg.TicketID).Info("Message processed by another delivery, skipping")
+			return nil
+		}
+		log.WithError(err).Error("Failed to update ticket status")
+		return err
+	}
+
+	if owed := owe.Result(); owed.Count > 0 {
+		log.WithFields(log.Fields{
+			"ticket_id":  ticketMsg.TicketID,
+			"payment_id": result.ID,
+		}).Warn("Ticket left pending during payment, refunding")
+		// A failed refund stays due and is retried by the sweeper
+		p.refundDue(ctx, ticketMsg.TicketID, result.ID)
+		return nil
+	}
+
+	if confirmed := confirm.Result(); confirmed.Count == 0 {
+		// Another delivery of the same attempt (a re-published message or a
+		// second replica) got there first. It shares the payment, which must
+		// not be refunded.
+		return p.skipConfirmed(ctx, ticketMsg.TicketID, result.ID)
+	}
+
+	updatedTicket, err := p.dbService.Client.Ticket.FindUnique(
+		db.Ticket.ID.Equals(ticketMsg.TicketID),
 	).Exec(ctx)
 
 	if err != nil {
-		log.WithError(err).Error("Failed to update ticket status")
+		log.WithError(err).Error("Failed to reload confirmed ticket")
 		return err
 	}
 
@@ -147,20 +463,125 @@ func processTicketMessage(msg amqp.Delivery, dbService *services.DatabaseService
 		"ticket_id": updatedTicket.ID,
 		"status":    updatedTicket.Status,
 	}).Info("Ticket confirmed successfully")
+	metrics.TicketOperations.WithLabelValues("payment", "captured", serviceName).Inc()
+	p.publishStatus(ctx, updatedTicket, ticket.Status)
+
+	return p.completeConfirmation(ctx, updatedTicket)
+}
 
-	// Send confirmation email (mock)
-	sendConfirmationEmail(ticketMsg)
+// skipConfirmed handles a delivery that lost the confirmation to another one.
+// If the ticket was confirmed with the same payment the confirmation is
+// completed, since the winner may not have got that far yet; any other state
+// was settled by whoever changed it.
+func (p *ticketProcessor) skipConfirmed(ctx context.Context, ticketID, paymentID string) error {
+	ticket, err := p.dbService.Client.Ticket.FindUnique(
+		db.Ticket.ID.Equals(ticketID),
+	).Exec(ctx)
 
+	if err != nil {
+		log.WithError(err).Error("Failed to reload ticket")
+		return err
+	}
+
+	if confirmedWith, _ := ticket.PaymentID(); ticket.Status == "confirmed" && confirmedWith == paymentID {
+		log.WithField("ticket_id", ticketID).Info("Ticket confirmed by another delivery")
+		return p.completeConfirmation(ctx, ticket)
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id":  ticketID,
+		"payment_id": paymentID,
+		"status":     ticket.Status,
+	}).Info("Ticket left pending during payment, payment already settled")
 	return nil
 }
 
-func sendConfirmationEmail(ticketMsg types.TicketMessage) {
-	// Mock email sending
-	log.WithFields(log.Fields{
-		"ticket_id": ticketMsg.TicketID,
-		"user_id":   ticketMsg.UserID,
-		"event_id":  ticketMsg.EventID,
-	}).Info("📧 Sending confirmation email (mock)")
+// completeConfirmation issues the invoice, publishes ticket.confirmed,
+// requests the confirmation email and enqueues the confirmation webhook. On
+// failure the message is redelivered and the already-confirmed path retries
+// all of them; the notification and webhook are deduplicated and
+// ticket.confirmed keeps its message ID.
+func (p *ticketProcessor) completeConfirmation(ctx context.Context, ticket *db.TicketModel) error {
+	if _, err := p.invoiceService.IssueForTicket(ctx, ticket); err != nil {
+		log.WithError(err).Error("Failed to issue invoice")
+		return err
+	}
+
+	if err := p.rmqService.PublishTicketConfirmed(ctx, ticketConfirmedMessage(ticket)); err != nil {
+		log.WithError(err).Error("Failed to publish ticket confirmed message")
+		return err
+	}
+
+	if err := p.rmqService.PublishTicketNotification(ctx, types.TicketNotificationMessage{
+		Type:      types.NotificationTicketConfirmed,
+		TicketID:  ticket.ID,
+		UserID:    ticket.UserID,
+		EventID:   ticket.EventID,
+		Status:    ticket.Status,
+		Timestamp: time.Now(),
+	}); err != nil {
+		log.WithError(err).Error("Failed to publish confirmation notification")
+		return err
+	}
+
+	if err := p.webhookService.Enqueue(ctx, webhook.EventTicketConfirmed, ticket); err != nil {
+		log.WithError(err).Error("Failed to enqueue confirmation webhook")
+		return err
+	}
+
+	return nil
+}
+
+func ticketConfirmedMessage(ticket *db.TicketModel) types.TicketConfirmedMessage {
+	paymentID, _ := ticket.PaymentID()
+	organizerID, _ := ticket.OrganizerID()
+	// Tickets confirmed before confirmedAt was recorded
+	confirmedAt, ok := ticket.ConfirmedAt()
+	if !ok {
+		confirmedAt = ticket.UpdatedAt
+	}
+
+	return types.TicketConfirmedMessage{
+		TicketID:    ticket.ID,
+		UserID:      ticket.UserID,
+		EventID:     ticket.EventID,
+		OrganizerID: organizerID,
+		Quantity:    ticket.Quantity,
+		TotalPrice:  ticket.TotalPrice,
+		PaymentID:   paymentID,
+		ConfirmedAt: confirmedAt,
+		Timestamp:   time.Now(),
+	}
+}
+
+// publishStatus announces a status change to live streams in the API.
+// Streams are a convenience on top of polling, so failures are only logged.
+func (p *ticketProcessor) publishStatus(ctx context.Context, ticket *db.TicketModel, previousStatus string) {
+	failureReason, _ := ticket.FailureReason()
+	if err := p.rmqService.PublishTicketStatus(ctx, types.TicketStatusMessage{
+		TicketID:       ticket.ID,
+		UserID:         ticket.UserID,
+		EventID:        ticket.EventID,
+		Status:         ticket.Status,
+		PreviousStatus: previousStatus,
+		FailureReason:  failureReason,
+		Quantity:       ticket.Quantity,
+		TotalPrice:     ticket.TotalPrice,
+		Timestamp:      ticket.UpdatedAt,
+	}); err != nil {
+		log.WithError(err).WithField("ticket_id", ticket.ID).Warn("Failed to publish ticket status")
+	}
+}
+
+// permanentError marks failures that will not succeed on redelivery
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
 
-	// In production: integrate with SendGrid, AWS SES, or similar
+func (e *permanentError) Unwrap() error {
+	return e.err
 }
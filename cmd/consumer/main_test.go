@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/payment"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() *configs.Config {
+	cfg := &configs.Config{}
+	cfg.RabbitMQ = configs.RabbitMQConfig{
+		Exchange:           "ticket_exchange",
+		EventExchange:      "event_exchange",
+		StatusExchange:     "ticket_status",
+		DeadLetterExchange: "ticket_dlx",
+		MaxRetries:         2,
+	}
+	cfg.RabbitMQ.Queue = configs.RabbitMQQueueConfig{
+		Purchased:      "ticket.purchased",
+		Confirmed:      "ticket.confirmed",
+		PaymentFailed:  "ticket.payment_failed",
+		Notification:   "ticket.notification",
+		EventLifecycle: "ticket-service.event-lifecycle",
+	}
+	cfg.Consumer = configs.ConsumerConfig{Workers: 2, PrefetchCount: 4}
+	cfg.Payment.Currency = "SEK"
+	return cfg
+}
+
+// chargeFromMessage runs the payment part of processTicketMessage, with the
+// ticket taken from the message instead of the database: a captured payment
+// confirms the ticket, a declined one fails it
+func chargeFromMessage(p *ticketProcessor, rmq broker.Broker) func(broker.Delivery) error {
+	return func(msg broker.Delivery) error {
+		ticketMsg, _, err := decodeMessage[types.TicketMessage](p.decoder, msg, types.MessageTypeTicketPurchased)
+		if err != nil {
+			return err
+		}
+
+		ticket := &db.TicketModel{InnerTicket: db.InnerTicket{
+			ID:             ticketMsg.TicketID,
+			UserID:         ticketMsg.UserID,
+			EventID:        ticketMsg.EventID,
+			Quantity:       ticketMsg.Quantity,
+			TotalPrice:     ticketMsg.TotalPrice,
+			PaymentAttempt: ticketMsg.PaymentAttempt,
+		}}
+		ctx := context.Background()
+		result, err := p.chargeTicket(ctx, ticket, ticketMsg)
+		if err != nil {
+			return err
+		}
+		if result.Status != payment.StatusCaptured {
+			return p.publishPaymentFailed(ctx, ticket, result.DeclineCode, result.Message)
+		}
+		return rmq.PublishTicketConfirmed(ctx, types.TicketConfirmedMessage{TicketID: ticket.ID, PaymentID: result.ID})
+	}
+}
+
+func runDeliveries(t *testing.T, rmq *broker.Memory, cfg *configs.Config, process func(broker.Delivery) error) func() {
+	t.Helper()
+	msgs, err := rmq.ConsumeTicketPurchased()
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handleDeliveries(context.Background(), rmq, &cfg.Consumer, cfg.RabbitMQ.Queue.Purchased, msgs, process)
+	}()
+
+	return func() {
+		require.NoError(t, rmq.CancelConsumers())
+		wg.Wait()
+	}
+}
+
+func TestHandleDeliveriesChargesPurchases(t *testing.T) {
+	cfg := testConfig()
+	rmq := broker.NewMemory(cfg)
+	defer rmq.Close()
+
+	payments := payment.NewFakeProvider()
+	p := &ticketProcessor{cfg: cfg, rmqService: rmq, payments: payments, decoder: newMessageDecoder()}
+
+	confirmed, err := rmq.Consume(cfg.RabbitMQ.Queue.Confirmed)
+	require.NoError(t, err)
+	failed, err := rmq.Consume(cfg.RabbitMQ.Queue.PaymentFailed)
+	require.NoError(t, err)
+
+	stop := runDeliveries(t, rmq, cfg, chargeFromMessage(p, rmq))
+	defer stop()
+
+	ctx := context.Background()
+	require.NoError(t, rmq.PublishTicketPurchased(ctx, types.TicketMessage{TicketID: "t1", EventID: "e1", Quantity: 2, TotalPrice: 100}))
+	// The fake provider declines amounts ending in .02
+	require.NoError(t, rmq.PublishTicketPurchased(ctx, types.TicketMessage{TicketID: "t2", EventID: "e1", Quantity: 1, TotalPrice: 50.02}))
+
+	confirmation := receive(t, confirmed)
+	assert.Equal(t, types.MessageTypeTicketConfirmed, confirmation.Type)
+	assert.Equal(t, broker.ConfirmedMessageID("t1"), confirmation.MessageID)
+	require.NoError(t, confirmation.Ack())
+
+	failure := receive(t, failed)
+	assert.Equal(t, types.MessageTypeTicketPaymentFailed, failure.Type)
+	require.NoError(t, failure.Ack())
+
+	charged, err := payments.Find(ctx, "t1")
+	require.NoError(t, err)
+	assert.Equal(t, payment.StatusCaptured, charged.Status)
+	assert.Equal(t, int64(10000), charged.AmountCents)
+
+	declined, err := payments.Find(ctx, "t2")
+	require.NoError(t, err)
+	assert.Equal(t, payment.StatusDeclined, declined.Status)
+}
+
+func TestHandleDeliveriesRetriesAndDeadLetters(t *testing.T) {
+	cfg := testConfig()
+	rmq := broker.NewMemory(cfg)
+	defer rmq.Close()
+
+	payments := payment.NewFakeProvider()
+	p := &ticketProcessor{cfg: cfg, rmqService: rmq, payments: payments, decoder: newMessageDecoder()}
+	charge := chargeFromMessage(p, rmq)
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	done := make(chan string, 4)
+	stop := runDeliveries(t, rmq, cfg, func(msg broker.Delivery) error {
+		key := deliveryKey(msg)
+		mu.Lock()
+		attempts[key]++
+		attempt := attempts[key]
+		mu.Unlock()
+		defer func() { done <- key }()
+
+		switch key {
+		case "t1":
+			// Fails once, the retry succeeds
+			if attempt == 1 {
+				return assert.AnError
+			}
+			return charge(msg)
+		default:
+			return assert.AnError
+		}
+	})
+
+	ctx := context.Background()
+	require.NoError(t, rmq.PublishTicketPurchased(ctx, types.TicketMessage{TicketID: "t1", TotalPrice: 100}))
+	require.NoError(t, rmq.PublishTicketPurchased(ctx, types.TicketMessage{TicketID: "t2", TotalPrice: 100}))
+
+	// t1 twice, t2 once plus its retries
+	for i := 0; i < 2+1+cfg.RabbitMQ.MaxRetries; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("delivery was not processed")
+		}
+	}
+	stop()
+
+	charged, err := payments.Find(ctx, "t1")
+	require.NoError(t, err)
+	assert.Equal(t, payment.StatusCaptured, charged.Status)
+
+	dead := rmq.Messages(broker.DeadLetterQueueName(cfg.RabbitMQ.Queue.Purchased))
+	require.Len(t, dead, 1)
+	assert.Equal(t, "t2", deliveryKey(dead[0]))
+	assert.Contains(t, dead[0].Headers[broker.HeaderDeadLetterReason], "retries exhausted")
+}
+
+func TestHandleDeliveriesDeadLettersPermanentErrors(t *testing.T) {
+	cfg := testConfig()
+	rmq := broker.NewMemory(cfg)
+	defer rmq.Close()
+
+	p := &ticketProcessor{cfg: cfg, rmqService: rmq, payments: payment.NewFakeProvider(), decoder: newMessageDecoder()}
+	done := make(chan struct{}, 1)
+	stop := runDeliveries(t, rmq, cfg, func(msg broker.Delivery) error {
+		defer func() { done <- struct{}{} }()
+		return chargeFromMessage(p, rmq)(msg)
+	})
+
+	// A body that isn't a ticket message can never be processed
+	require.NoError(t, rmq.PublishOutboxMessage(context.Background(), cfg.RabbitMQ.Exchange, cfg.RabbitMQ.Queue.Purchased, "msg-1", "t1", []byte(`not json`)))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("delivery was not processed")
+	}
+	stop()
+
+	dead := rmq.Messages(broker.DeadLetterQueueName(cfg.RabbitMQ.Queue.Purchased))
+	require.Len(t, dead, 1)
+	assert.Equal(t, "msg-1", dead[0].MessageID)
+	assert.Equal(t, 0, broker.RetryCount(dead[0]), "permanent errors skip the retries")
+	assert.NotContains(t, dead[0].Headers[broker.HeaderDeadLetterReason], "retries exhausted")
+}
+
+func receive(t *testing.T, msgs <-chan broker.Delivery) broker.Delivery {
+	t.Helper()
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no delivery received")
+		return broker.Delivery{}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+)
+
+// newMessageDecoder registers every schema version of the message types the
+// consumer handles. When a message struct gets a new version, keep decoding
+// the previous one with envelope.Upgrade until no producer sends it anymore.
+func newMessageDecoder() *envelope.Decoder {
+	decoder := envelope.NewDecoder()
+	decoder.Register(types.MessageTypeTicketPurchased, 1, envelope.JSON[types.TicketMessage]())
+	decoder.Register(types.MessageTypeTicketNotification, 1, envelope.JSON[types.TicketNotificationMessage]())
+	decoder.Register(types.MessageTypeEventCancelled, 1, envelope.JSON[types.EventLifecycleMessage]())
+	decoder.Register(types.MessageTypeEventRescheduled, 1, envelope.JSON[types.EventLifecycleMessage]())
+	return decoder
+}
+
+// decodeMessage decodes a delivery by the type and schema version in its
+// envelope. Deliveries without an envelope are decoded as version 1 of
+// legacyType. Errors are permanent: redelivering the message can't fix them.
+func decodeMessage[T any](decoder *envelope.Decoder, msg broker.Delivery, legacyType string) (T, envelope.Envelope, error) {
+	var zero T
+
+	env, err := broker.MessageEnvelope(msg, legacyType)
+	if err != nil {
+		return zero, env, &permanentError{err: err}
+	}
+
+	decoded, err := decoder.Decode(env, msg.Body)
+	if err != nil {
+		return zero, env, &permanentError{err: err}
+	}
+
+	typed, ok := decoded.(T)
+	if !ok {
+		return zero, env, &permanentError{err: fmt.Errorf("unexpected message type %s", env.Type)}
+	}
+	return typed, env, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// processNotificationMessage emails the ticket holder about a ticket change
+func (p *ticketProcessor) processNotificationMessage(msg broker.Delivery) error {
+	notificationMsg, env, err := decodeMessage[types.TicketNotificationMessage](p.decoder, msg, types.MessageTypeTicketNotification)
+	if err != nil {
+		log.WithError(err).Error("Failed to decode notification message")
+		return err
+	}
+
+	if !p.cfg.Notifications.Enabled {
+		log.WithField("ticket_id", notificationMsg.TicketID).Debug("Notifications disabled, dropping message")
+		return nil
+	}
+
+	// Sending retries transient failures itself, so allow for the backoff
+	ctx, cancel := context.WithTimeout(envelope.WithCorrelationID(context.Background(), env.CorrelationID), time.Minute)
+	defer cancel()
+
+	ticket, err := p.dbService.Client.Ticket.FindUnique(
+		db.Ticket.ID.Equals(notificationMsg.TicketID),
+	).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to find ticket for notification")
+		if errors.Is(err, db.ErrNotFound) {
+			return &permanentError{err: err}
+		}
+		return err
+	}
+
+	if err := p.notificationService.Notify(ctx, ticket, notificationMsg); err != nil {
+		if errors.Is(err, services.ErrUnknownNotification) || errors.Is(err, services.ErrNotificationRejected) {
+			// Straight to the dead-letter queue instead of retrying
+			return &permanentError{err: err}
+		}
+		return err
+	}
+
+	return nil
+}
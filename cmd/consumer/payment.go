@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/invoice"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/metrics"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/payment"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// chargeTicket authorizes and captures the ticket price. The idempotency key
+// is derived from the ticket ID and payment attempt, so a redelivered message
+// resumes the same payment instead of charging the buyer twice, while a retry
+// after a decline starts a new one.
+func (p *ticketProcessor) chargeTicket(ctx context.Context, ticket *db.TicketModel, ticketMsg types.TicketMessage) (*payment.Result, error) {
+	result, err := p.payments.Authorize(ctx, payment.AuthorizeRequest{
+		IdempotencyKey: paymentKey(ticket),
+		AmountCents:    invoice.ToCents(ticket.TotalPrice),
+		Currency:       p.cfg.Payment.Currency,
+		PaymentMethod:  ticketMsg.PaymentMethod,
+		Description:    fmt.Sprintf("%d ticket(s) for event %s", ticket.Quantity, ticket.EventID),
+		Metadata: map[string]string{
+			"ticket_id": ticket.ID,
+			"event_id":  ticket.EventID,
+			"user_id":   ticket.UserID,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize payment: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id":  ticket.ID,
+		"payment_id": result.ID,
+		"status":     result.Status,
+	}).Info("Payment authorized")
+
+	if result.Status == payment.StatusProcessing {
+		// A replayed authorization returns the original response, so ask
+		// for the current state
+		current, err := p.payments.Status(ctx, result.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get payment status %s: %w", result.ID, err)
+		}
+		result = current
+	}
+
+	switch result.Status {
+	case payment.StatusProcessing:
+		// Retried through the delay queues; a payment that never settles is
+		// cancelled by the sweeper before the ticket expires
+		return nil, fmt.Errorf("payment %s is still processing", result.ID)
+	case payment.StatusAuthorized:
+	default:
+		return result, nil
+	}
+
+	captured, err := p.payments.Capture(ctx, result.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture payment %s: %w", result.ID, err)
+	}
+
+	return captured, nil
+}
+
+// paymentKey is the idempotency key of the ticket's current payment attempt
+func paymentKey(ticket *db.TicketModel) string {
+	if ticket.PaymentAttempt > 0 {
+		return fmt.Sprintf("%s-%d", ticket.ID, ticket.PaymentAttempt)
+	}
+	return ticket.ID
+}
+
+// failPayment moves a declined ticket to payment_failed and announces it so
+// the held capacity can be released. The update only applies while the ticket
+// is still pending with the same attempt: a cancel or expiry during the
+// authorization stands. The message is published once the update committed;
+// if publishing fails the message is redelivered and the payment_failed path
+// publishes it again.
+func (p *ticketProcessor) failPayment(ctx context.Context, env envelope.Envelope, ticket *db.TicketModel, result *payment.Result) error {
+	reasonCode := result.DeclineCode
+	if reasonCode == "" {
+		reasonCode = "payment_declined"
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id":   ticket.ID,
+		"payment_id":  result.ID,
+		"reason_code": reasonCode,
+	}).Warn("Payment declined")
+
+	fail := p.dbService.Client.Ticket.FindMany(
+		db.Ticket.ID.Equals(ticket.ID),
+		db.Ticket.Status.Equals("pending"),
+		db.Ticket.PaymentAttempt.Equals(ticket.PaymentAttempt),
+	).Update(
+		db.Ticket.Status.Set("payment_failed"),
+		db.Ticket.FailureReason.Set(reasonCode),
+	).Tx()
+	if err := p.ledger.Commit(ctx, env, fail); err != nil {
+		if errors.Is(err, services.ErrAlreadyProcessed) {
+			// Failed by another delivery of the message
+			return nil
+		}
+		return fmt.Errorf("failed to mark ticket payment failed: %w", err)
+	}
+
+	if failed := fail.Result(); failed.Count == 0 {
+		log.WithField("ticket_id", ticket.ID).Info("Ticket left pending during payment, not marking it failed")
+		return nil
+	}
+
+	metrics.TicketOperations.WithLabelValues("payment", "failed", serviceName).Inc()
+
+	failedTicket := *ticket
+	failedTicket.Status = "payment_failed"
+	failedTicket.UpdatedAt = time.Now()
+	failedTicket.InnerTicket.FailureReason = &reasonCode
+	p.publishStatus(ctx, &failedTicket, ticket.Status)
+
+	return p.publishPaymentFailed(ctx, ticket, reasonCode, result.Message)
+}
+
+// publishPaymentFailed announces a ticket whose payment failed
+func (p *ticketProcessor) publishPaymentFailed(ctx context.Context, ticket *db.TicketModel, reasonCode, reason string) error {
+	if err := p.rmqService.PublishTicketPaymentFailed(ctx, types.TicketPaymentFailedMessage{
+		TicketID:   ticket.ID,
+		UserID:     ticket.UserID,
+		EventID:    ticket.EventID,
+		Quantity:   ticket.Quantity,
+		ReasonCode: reasonCode,
+		Reason:     reason,
+		Timestamp:  time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to publish payment failed message: %w", err)
+	}
+	return nil
+}
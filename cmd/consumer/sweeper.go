@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/metrics"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/payment"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// runSweeper periodically recovers tickets stuck in pending, e.g. because the
+// purchase message was never published or got lost. Stale tickets are
+// re-published up to MaxRecoveries times and expired after that. It also
+// retries refunds that are still due.
+func (p *ticketProcessor) runSweeper(ctx context.Context) {
+	interval := time.Duration(p.cfg.Sweeper.IntervalSeconds) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.WithFields(log.Fields{
+		"interval":    interval,
+		"pending_age": time.Duration(p.cfg.Sweeper.PendingAgeSeconds) * time.Second,
+	}).Info("Pending ticket sweeper started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Pending ticket sweeper stopped")
+			return
+		case <-ticker.C:
+			p.sweepPendingTickets(ctx)
+			p.sweepDueRefunds(ctx)
+		}
+	}
+}
+
+func (p *ticketProcessor) sweepPendingTickets(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	cutoff := time.Now().Add(-time.Duration(p.cfg.Sweeper.PendingAgeSeconds) * time.Second)
+	tickets, err := p.dbService.Client.Ticket.FindMany(
+		db.Ticket.Status.Equals("pending"),
+		db.Ticket.UpdatedAt.Lt(cutoff),
+	).OrderBy(
+		db.Ticket.UpdatedAt.Order(db.ASC),
+	).Take(p.cfg.Sweeper.BatchSize).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch stale pending tickets")
+		return
+	}
+
+	var recovered, expired int
+	for i := range tickets {
+		ticket := &tickets[i]
+		if ticket.RecoveryCount >= p.cfg.Sweeper.MaxRecoveries {
+			if p.expireTicket(ctx, ticket, cutoff) {
+				expired++
+			}
+			continue
+		}
+		if p.recoverTicket(ctx, ticket) {
+			recovered++
+		}
+	}
+
+	if recovered > 0 || expired > 0 {
+		log.WithFields(log.Fields{
+			"recovered": recovered,
+			"expired":   expired,
+		}).Info("Swept stale pending tickets")
+	}
+}
+
+// recoverTicket re-publishes the purchase message. The conditional update
+// claims the ticket (and bumps updatedAt) so concurrent sweepers in other
+// consumer replicas don't publish it twice in the same round.
+func (p *ticketProcessor) recoverTicket(ctx context.Context, ticket *db.TicketModel) bool {
+	result, err := p.dbService.Client.Ticket.FindMany(
+		db.Ticket.ID.Equals(ticket.ID),
+		db.Ticket.Status.Equals("pending"),
+		db.Ticket.RecoveryCount.Equals(ticket.RecoveryCount),
+	).Update(
+		db.Ticket.RecoveryCount.Increment(1),
+	).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).WithField("ticket_id", ticket.ID).Error("Failed to claim pending ticket")
+		return false
+	}
+	if result.Count == 0 {
+		return false
+	}
+
+	if err := p.rmqService.PublishTicketPurchased(ctx, types.TicketMessage{
+		TicketID:       ticket.ID,
+		UserID:         ticket.UserID,
+		EventID:        ticket.EventID,
+		Quantity:       ticket.Quantity,
+		TotalPrice:     ticket.TotalPrice,
+		Timestamp:      time.Now(),
+		PaymentAttempt: ticket.PaymentAttempt,
+	}); err != nil {
+		// The ticket is picked up again once it is stale, until it expires
+		log.WithError(err).WithField("ticket_id", ticket.ID).Error("Failed to re-publish pending ticket")
+		return false
+	}
+
+	metrics.PendingTicketsSwept.WithLabelValues("recovered", serviceName).Inc()
+	return true
+}
+
+// expireTicket gives up on a ticket that stayed pending through all recovery
+// attempts and announces it so held capacity can be released. The payment
+// provider is asked first, since the payment may have gone through even
+// though the ticket was never confirmed: a captured payment is recorded as
+// owed together with the expiry and refunded, an authorized one is released
+// and one still processing is cancelled first.
+func (p *ticketProcessor) expireTicket(ctx context.Context, ticket *db.TicketModel, cutoff time.Time) bool {
+	charged, err := p.payments.Find(ctx, paymentKey(ticket))
+	if errors.Is(err, payment.ErrPaymentNotFound) {
+		charged = nil
+	} else if err != nil {
+		// Expiring without knowing could strand a captured payment
+		log.WithError(err).WithField("ticket_id", ticket.ID).Error("Failed to look up payment of pending ticket")
+		return false
+	}
+
+	if charged != nil && charged.Status == payment.StatusProcessing {
+		// The payment may still go through, so it is stopped before the
+		// ticket is given up
+		cancelled, err := p.payments.Cancel(ctx, charged.ID)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"ticket_id":  ticket.ID,
+				"payment_id": charged.ID,
+			}).Warn("Failed to cancel unsettled payment of pending ticket")
+			return false
+		}
+		charged = cancelled
+	}
+
+	update := []db.TicketSetParam{
+		db.Ticket.Status.Set("expired"),
+		db.Ticket.FailureReason.Set("pending_timeout"),
+	}
+	if charged != nil && charged.Status == payment.StatusCaptured {
+		update = append(update,
+			db.Ticket.OwedPaymentID.Set(charged.ID),
+			db.Ticket.RefundStatus.Set("due"),
+		)
+	}
+
+	result, err := p.dbService.Client.Ticket.FindMany(
+		db.Ticket.ID.Equals(ticket.ID),
+		db.Ticket.Status.Equals("pending"),
+		db.Ticket.UpdatedAt.Lt(cutoff),
+	).Update(update...).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).WithField("ticket_id", ticket.ID).Error("Failed to expire pending ticket")
+		return false
+	}
+	if result.Count == 0 {
+		return false
+	}
+
+	if charged != nil {
+		switch charged.Status {
+		case payment.StatusCaptured:
+			// A failed refund stays due and is retried by the sweeper
+			p.refundDue(ctx, ticket.ID, charged.ID)
+		case payment.StatusAuthorized:
+			// Otherwise the hold on the buyer's card lapses at the provider
+			if _, err := p.payments.Cancel(ctx, charged.ID); err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"ticket_id":  ticket.ID,
+					"payment_id": charged.ID,
+				}).Warn("Failed to release authorized payment of expired ticket")
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id":      ticket.ID,
+		"recovery_count": ticket.RecoveryCount,
+	}).Warn("Expired pending ticket")
+	metrics.PendingTicketsSwept.WithLabelValues("expired", serviceName).Inc()
+
+	expired := *ticket
+	expired.Status = "expired"
+	expired.UpdatedAt = time.Now()
+	reason := "pending_timeout"
+	expired.InnerTicket.FailureReason = &reason
+	p.publishStatus(ctx, &expired, ticket.Status)
+
+	if err := p.rmqService.PublishTicketPaymentFailed(ctx, types.TicketPaymentFailedMessage{
+		TicketID:   ticket.ID,
+		UserID:     ticket.UserID,
+		EventID:    ticket.EventID,
+		Quantity:   ticket.Quantity,
+		ReasonCode: "pending_timeout",
+		Reason:     "Ticket expired while waiting for payment",
+		Timestamp:  time.Now(),
+	}); err != nil {
+		log.WithError(err).WithField("ticket_id", ticket.ID).Error("Failed to publish expired ticket message")
+	}
+
+	return true
+}
+
+// sweepDueRefunds refunds payments owed back to buyers: those of cancelled
+// confirmed tickets and those captured for tickets that were cancelled or
+// expired while the payment was in flight
+func (p *ticketProcessor) sweepDueRefunds(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tickets, err := p.dbService.Client.Ticket.FindMany(
+		db.Ticket.RefundStatus.Equals("due"),
+	).OrderBy(
+		db.Ticket.UpdatedAt.Order(db.ASC),
+	).Take(p.cfg.Sweeper.BatchSize).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch due refunds")
+		return
+	}
+
+	for _, ticket := range tickets {
+		if paymentID, ok := ticket.OwedPaymentID(); ok {
+			p.refundDue(ctx, ticket.ID, paymentID)
+		}
+	}
+}
+
+// refundDue refunds a payment owed back to the buyer and marks it refunded.
+// Refunds are idempotent per payment, so retrying one whose status update
+// failed doesn't refund the buyer twice.
+func (p *ticketProcessor) refundDue(ctx context.Context, ticketID, paymentID string) bool {
+	if _, err := p.payments.Refund(ctx, paymentID, 0); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"ticket_id":  ticketID,
+			"payment_id": paymentID,
+		}).Error("Failed to refund payment, retrying later")
+		return false
+	}
+
+	if _, err := p.dbService.Client.Ticket.FindMany(
+		db.Ticket.ID.Equals(ticketID),
+		db.Ticket.OwedPaymentID.Equals(paymentID),
+		db.Ticket.RefundStatus.Equals("due"),
+	).Update(
+		db.Ticket.RefundStatus.Set("refunded"),
+	).Exec(ctx); err != nil {
+		log.WithError(err).WithField("ticket_id", ticketID).Error("Failed to mark refund done")
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id":  ticketID,
+		"payment_id": paymentID,
+	}).Info("Refunded owed payment")
+	metrics.TicketOperations.WithLabelValues("payment", "refunded", serviceName).Inc()
+	return true
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/oskargbc/dws-ticket-service/configs"
-	"github.com/oskargbc/dws-ticket-service/internal/pkg/rabbitmq"
 	"github.com/oskargbc/dws-ticket-service/internal/router"
 	"github.com/oskargbc/dws-ticket-service/internal/services"
 	log "github.com/sirupsen/logrus"
@@ -50,7 +49,7 @@ func main() {
 	}()
 
 	// Initialize RabbitMQ
-	rmqService, err := rabbitmq.GetRabbitMQServiceInstance(cfg)
+	rmqService, err := services.NewBroker(cfg)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to initialize RabbitMQ service")
 	}
@@ -60,8 +59,17 @@ func main() {
 		}
 	}()
 
+	// Relay outbox messages to RabbitMQ until shutdown
+	outboxService := services.NewOutboxService(cfg, dbService, rmqService)
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		outboxService.Run(relayCtx)
+	}()
+
 	// Setup router
-	r := router.SetupRouter(cfg, dbService, rmqService)
+	r := router.SetupRouter(cfg, dbService, rmqService, outboxService)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -94,5 +102,9 @@ func main() {
 		log.WithError(err).Fatal("Server forced to shutdown")
 	}
 
+	// Unpublished messages stay in the outbox for the next start
+	stopRelay()
+	<-relayDone
+
 	log.Info("Server exited")
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const usage = `ticketctl is the operator CLI of dws-ticket-service.
+
+Usage:
+  ticketctl dlq list   [-queue <queue>] [-limit <n>] [-json]
+  ticketctl dlq show   [-queue <queue>] <message-id>
+  ticketctl dlq replay [-queue <queue>] <message-id>...
+  ticketctl dlq purge  [-queue <queue>] (-all | <message-id>...)
+
+The queue is the work queue whose dead-letter queue is used, ticket.purchased
+by default. Replayed messages go back onto that queue with all their retries.
+The broker is configured like the server (config.yaml, RABBITMQ_URL).
+`
+
+func main() {
+	// Keep the output for the command results
+	log.SetOutput(os.Stderr)
+	log.SetLevel(log.WarnLevel)
+
+	if len(os.Args) < 3 || os.Args[1] != "dlq" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := runDLQ(os.Args[2], os.Args[3:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "ticketctl:", err)
+		os.Exit(1)
+	}
+}
+
+func runDLQ(command string, args []string, out io.Writer) error {
+	cfg, err := configs.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	flags := flag.NewFlagSet("dlq "+command, flag.ContinueOnError)
+	queue := flags.String("queue", cfg.RabbitMQ.Queue.Purchased, "work queue of the dead-letter queue")
+	limit := flags.Int("limit", 100, "maximum number of messages to list")
+	asJSON := flags.Bool("json", false, "print messages as JSON")
+	all := flags.Bool("all", false, "purge every message in the dead-letter queue")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	messageIDs := flags.Args()
+
+	switch command {
+	case "list", "show", "replay", "purge":
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+	}
+	if err := broker.CheckDeadLetterQueue(&cfg.RabbitMQ, *queue); err != nil {
+		return fmt.Errorf("%s: %w", *queue, err)
+	}
+	if cfg.RabbitMQ.Driver == "memory" {
+		// The in-process broker of another process can't be reached
+		return errors.New("ticketctl needs the amqp broker driver")
+	}
+
+	rmqService, err := services.NewBroker(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the broker: %w", err)
+	}
+	rmqService.SetServiceName("ticketctl")
+	defer rmqService.Close()
+
+	switch command {
+	case "list":
+		msgs, err := rmqService.DeadLetters(*queue, *limit)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(out, describe(msgs, true))
+		}
+		return printTable(out, msgs)
+
+	case "show":
+		if len(messageIDs) != 1 {
+			return errors.New("show takes one message ID")
+		}
+		msgs, err := rmqService.DeadLetters(*queue, 0)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if msg.MessageID == messageIDs[0] {
+				return printJSON(out, broker.DescribeDeadLetter(msg, true))
+			}
+		}
+		return fmt.Errorf("message %s not found in %s", messageIDs[0], broker.DeadLetterQueueName(*queue))
+
+	case "replay":
+		if len(messageIDs) == 0 {
+			return errors.New("replay takes at least one message ID")
+		}
+		replayed, err := rmqService.ReplayDeadLetters(*queue, messageIDs)
+		fmt.Fprintf(out, "Replayed %d message(s) onto %s\n", replayed, *queue)
+		return err
+
+	default:
+		if (len(messageIDs) == 0) == !*all {
+			return errors.New("purge takes either message IDs or -all")
+		}
+		purged, err := rmqService.PurgeDeadLetters(*queue, messageIDs)
+		fmt.Fprintf(out, "Purged %d message(s) from %s\n", purged, broker.DeadLetterQueueName(*queue))
+		return err
+	}
+}
+
+func describe(msgs []broker.Delivery, withBody bool) []types.DeadLetterMessage {
+	described := make([]types.DeadLetterMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		described = append(described, broker.DescribeDeadLetter(msg, withBody))
+	}
+	return described
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func printTable(out io.Writer, msgs []broker.Delivery) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MESSAGE ID\tTYPE\tRETRIES\tDEAD-LETTERED\tREASON")
+	for _, msg := range msgs {
+		d := broker.DescribeDeadLetter(msg, false)
+		at := "-"
+		if d.DeadLetteredAt != nil {
+			at = d.DeadLetteredAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", d.MessageID, d.Type, d.RetryCount, at, d.Reason)
+	}
+	return w.Flush()
+}
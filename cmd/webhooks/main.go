@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	// Configure logging
+	log.SetFormatter(&log.JSONFormatter{})
+	log.SetOutput(os.Stdout)
+	log.SetLevel(log.InfoLevel)
+
+	// Load configuration
+	cfg, err := configs.LoadConfig()
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load configuration")
+	}
+
+	if !cfg.Webhooks.Enabled {
+		log.Info("Webhooks are disabled, exiting")
+		return
+	}
+
+	log.Info("Starting dws-ticket-service webhook worker")
+
+	// Initialize database
+	dbService, err := services.GetDatabaseServiceInstance(cfg)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize database service")
+	}
+	defer func() {
+		if err := dbService.Disconnect(); err != nil {
+			log.WithError(err).Error("Failed to disconnect from database")
+		}
+	}()
+
+	webhookService := services.NewWebhookService(cfg, dbService)
+
+	// Handle graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	runWorker(ctx, webhookService, time.Duration(cfg.Webhooks.PollIntervalSeconds)*time.Second)
+	log.Info("Webhook worker stopped")
+}
+
+// runWorker polls for due deliveries until ctx is cancelled. A full batch is
+// followed immediately by the next one so a backlog drains quickly.
+func runWorker(ctx context.Context, webhookService *services.WebhookService, interval time.Duration) {
+	log.WithField("interval", interval).Info("Webhook worker started, waiting for deliveries...")
+
+	for {
+		attempted, err := deliverDue(ctx, webhookService)
+		if err != nil {
+			log.WithError(err).Error("Failed to deliver webhooks")
+		}
+
+		wait := interval
+		if attempted > 0 {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func deliverDue(ctx context.Context, webhookService *services.WebhookService) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	return webhookService.DeliverDue(ctx)
+}
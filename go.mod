@@ -14,6 +14,8 @@ require (
 	github.com/steebchen/prisma-client-go v0.47.0
 	github.com/streadway/amqp v1.1.0
 	github.com/stretchr/testify v1.11.1
+	go.mozilla.org/pkcs7 v0.9.0
+	golang.org/x/net v0.43.0
 )
 
 require (
@@ -61,7 +63,6 @@ require (
 	golang.org/x/arch v0.8.0 // indirect
 	golang.org/x/crypto v0.41.0 // indirect
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9 // indirect
-	golang.org/x/net v0.43.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
 	golang.org/x/text v0.28.0 // indirect
 	google.golang.org/protobuf v1.36.8 // indirect
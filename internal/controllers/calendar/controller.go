@@ -0,0 +1,262 @@
+package calendar
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/ical"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// uidDomain makes ticket UIDs globally unique (RFC 5545 section 3.8.4.7)
+const uidDomain = "dws-ticket-service"
+
+// CalendarController serves tickets as iCalendar data, either per ticket or
+// as a feed of all confirmed tickets that calendar apps can subscribe to. The
+// feed is authenticated by a secret token in its URL since calendar apps
+// can't send bearer tokens.
+type CalendarController struct {
+	dbService *services.DatabaseService
+	publicURL string
+}
+
+func NewCalendarController(dbSvc *services.DatabaseService, publicURL string) *CalendarController {
+	return &CalendarController{
+		dbService: dbSvc,
+		publicURL: publicURL,
+	}
+}
+
+// GetTicketCalendar handles GET /api/v1/tickets/:id/calendar.ics
+func (cc *CalendarController) GetTicketCalendar(c *gin.Context) {
+	ticketID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	ticket, err := cc.dbService.Client.Ticket.FindUnique(
+		db.Ticket.ID.Equals(ticketID),
+	).Exec(ctx)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Ticket not found",
+		})
+		return
+	}
+
+	if ticket.UserID != userID.(string) {
+		c.JSON(http.StatusForbidden, types.ErrorResponse{
+			Error:   "forbidden",
+			Message: "You don't have permission to view this ticket",
+		})
+		return
+	}
+
+	if ticket.Status != "confirmed" {
+		c.JSON(http.StatusConflict, types.ErrorResponse{
+			Error:   "invalid_status",
+			Message: fmt.Sprintf("Calendar entries are only available for confirmed tickets, ticket is %s", ticket.Status),
+		})
+		return
+	}
+
+	event, ok := calendarEvent(ticket)
+	if !ok {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "The event date is not known yet",
+		})
+		return
+	}
+
+	cal := ical.Calendar{Name: event.Summary, Events: []ical.Event{event}}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="ticket-%s.ics"`, ticket.ID))
+	c.Data(http.StatusOK, ical.ContentType, ical.Render(cal))
+}
+
+// CreateFeed handles POST /api/v1/calendar/feed. It issues a new secret feed
+// URL, revoking the previous one if any.
+func (cc *CalendarController) CreateFeed(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	token, err := newFeedToken()
+	if err != nil {
+		log.WithError(err).Error("Failed to generate calendar feed token")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to create calendar feed",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	// Only the hash is stored, the token itself is only ever returned here
+	tokenHash := hashFeedToken(token)
+	if _, err := cc.dbService.Client.CalendarFeed.UpsertOne(
+		db.CalendarFeed.UserID.Equals(userID.(string)),
+	).Create(
+		db.CalendarFeed.UserID.Set(userID.(string)),
+		db.CalendarFeed.TokenHash.Set(tokenHash),
+	).Update(
+		db.CalendarFeed.TokenHash.Set(tokenHash),
+	).Exec(ctx); err != nil {
+		log.WithError(err).Error("Failed to store calendar feed")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to create calendar feed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.CalendarFeedResponse{
+		URL: fmt.Sprintf("%s/api/v1/calendar/feeds/%s/tickets.ics", cc.publicURL, token),
+	})
+}
+
+// RevokeFeed handles DELETE /api/v1/calendar/feed
+func (cc *CalendarController) RevokeFeed(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, err := cc.dbService.Client.CalendarFeed.FindUnique(
+		db.CalendarFeed.UserID.Equals(userID.(string)),
+	).Delete().Exec(ctx); err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.WithError(err).Error("Failed to revoke calendar feed")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to revoke calendar feed",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetFeed handles GET /api/v1/calendar/feeds/:token/tickets.ics (no auth
+// required, the token is the credential)
+func (cc *CalendarController) GetFeed(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	feed, err := cc.dbService.Client.CalendarFeed.FindUnique(
+		db.CalendarFeed.TokenHash.Equals(hashFeedToken(c.Param("token"))),
+	).Exec(ctx)
+
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.WithError(err).Error("Failed to look up calendar feed")
+		}
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Calendar feed not found",
+		})
+		return
+	}
+
+	tickets, err := cc.dbService.Client.Ticket.FindMany(
+		db.Ticket.UserID.Equals(feed.UserID),
+		db.Ticket.Status.Equals("confirmed"),
+	).OrderBy(
+		db.Ticket.EventStartsAt.Order(db.ASC),
+	).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch tickets for calendar feed")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to load calendar feed",
+		})
+		return
+	}
+
+	cal := ical.Calendar{Name: "My tickets"}
+	for i := range tickets {
+		if event, ok := calendarEvent(&tickets[i]); ok {
+			cal.Events = append(cal.Events, event)
+		}
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Data(http.StatusOK, ical.ContentType, ical.Render(cal))
+}
+
+// calendarEvent maps a ticket to a VEVENT. Tickets whose event date is
+// unknown can't be put in a calendar.
+func calendarEvent(ticket *db.TicketModel) (ical.Event, bool) {
+	startsAt, ok := ticket.EventStartsAt()
+	if !ok {
+		return ical.Event{}, false
+	}
+
+	summary, ok := ticket.EventName()
+	if !ok || summary == "" {
+		summary = "Event " + ticket.EventID
+	}
+	location, _ := ticket.EventLocation()
+
+	event := ical.Event{
+		UID:          ticket.ID + "@" + uidDomain,
+		Sequence:     ticket.EventSequence,
+		Stamp:        time.Now(),
+		Start:        startsAt,
+		Summary:      summary,
+		Location:     location,
+		Description:  fmt.Sprintf("%d ticket(s)\nTicket ID: %s", ticket.Quantity, ticket.ID),
+		LastModified: ticket.UpdatedAt,
+	}
+	if endsAt, ok := ticket.EventEndsAt(); ok {
+		event.End = &endsAt
+	}
+	return event, true
+}
+
+func newFeedToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,369 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/eventclient"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/live"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// maxSubscriptions limits the events a single connection can watch
+	maxSubscriptions = 20
+	// flushInterval coalesces bursts of updates into one message per event
+	flushInterval = 250 * time.Millisecond
+	// writeTimeout disconnects clients that stop reading
+	writeTimeout = 5 * time.Second
+	// resyncInterval reloads counters from the database to correct drift
+	// from updates dropped for lagging subscribers
+	resyncInterval = time.Minute
+)
+
+// DashboardController pushes live per-event sales counters to organisers
+// over WebSocket. Organisers can only watch their own events.
+type DashboardController struct {
+	dbService      *services.DatabaseService
+	eventClient    *eventclient.Client
+	statusHub      *live.Hub
+	allowedOrigins []string
+}
+
+func NewDashboardController(dbSvc *services.DatabaseService, eventClient *eventclient.Client, statusHub *live.Hub, allowedOrigins []string) *DashboardController {
+	return &DashboardController{
+		dbService:      dbSvc,
+		eventClient:    eventClient,
+		statusHub:      statusHub,
+		allowedOrigins: allowedOrigins,
+	}
+}
+
+// clientMessage is sent by the client to change its subscriptions
+type clientMessage struct {
+	Action  string `json:"action"` // subscribe, unsubscribe
+	EventID string `json:"event_id"`
+}
+
+// serverMessage is pushed to the client
+type serverMessage struct {
+	Type    string              `json:"type"` // stats, error
+	Stats   *live.EventCounters `json:"stats,omitempty"`
+	Message string              `json:"message,omitempty"`
+}
+
+// LiveEventStats handles GET /api/v1/event-stats/live (WebSocket upgrade)
+func (dc *DashboardController) LiveEventStats(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: dc.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			log.WithField("user_id", userID).Info("Dashboard connection opened")
+			newSession(dc, ws, userID.(string)).run()
+			log.WithField("user_id", userID).Info("Dashboard connection closed")
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// checkOrigin applies the CORS allow-list to browser connections
+func (dc *DashboardController) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not a browser
+		return nil
+	}
+	for _, allowed := range dc.allowedOrigins {
+		if allowed == "*" || allowed == origin {
+			parsed, err := url.Parse(origin)
+			if err != nil {
+				return err
+			}
+			config.Origin = parsed
+			return nil
+		}
+	}
+	return websocket.ErrBadWebSocketOrigin
+}
+
+// session is a single dashboard connection. Updates from the hub are applied
+// to the counters as they arrive; a separate writer sends the changed
+// counters at most every flushInterval, so a slow client receives fewer,
+// coalesced messages instead of building up a queue.
+type session struct {
+	dc     *DashboardController
+	ws     *websocket.Conn
+	userID string
+
+	mu          sync.Mutex
+	counters    map[string]*live.EventCounters
+	snapshotAt  map[string]time.Time
+	unsubscribe map[string]func()
+	dirty       map[string]bool
+	errors      []string
+
+	notify chan struct{}
+	done   chan struct{}
+}
+
+func newSession(dc *DashboardController, ws *websocket.Conn, userID string) *session {
+	return &session{
+		dc:          dc,
+		ws:          ws,
+		userID:      userID,
+		counters:    make(map[string]*live.EventCounters),
+		snapshotAt:  make(map[string]time.Time),
+		unsubscribe: make(map[string]func()),
+		dirty:       make(map[string]bool),
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+func (s *session) run() {
+	// The HTTP server's deadlines still apply to the hijacked connection
+	_ = s.ws.SetDeadline(time.Time{})
+
+	go s.writeLoop()
+	defer func() {
+		close(s.done)
+		s.mu.Lock()
+		for _, unsubscribe := range s.unsubscribe {
+			unsubscribe()
+		}
+		s.mu.Unlock()
+		s.ws.Close()
+	}()
+
+	for {
+		var msg clientMessage
+		if err := websocket.JSON.Receive(s.ws, &msg); err != nil {
+			return
+		}
+
+		switch msg.Action {
+		case "subscribe":
+			s.subscribe(msg.EventID)
+		case "unsubscribe":
+			s.unsubscribeEvent(msg.EventID)
+		default:
+			s.fail("unknown action " + msg.Action)
+		}
+	}
+}
+
+func (s *session) subscribe(eventID string) {
+	if eventID == "" {
+		s.fail("event_id is required")
+		return
+	}
+
+	if err := s.authorize(eventID); err != nil {
+		s.fail(err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	if _, ok := s.unsubscribe[eventID]; ok {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.unsubscribe) >= maxSubscriptions {
+		s.mu.Unlock()
+		s.fail("too many subscriptions")
+		return
+	}
+	// Subscribe before loading the snapshot so no update is missed
+	updates, unsubscribe := s.dc.statusHub.SubscribeEvent(eventID)
+	s.unsubscribe[eventID] = unsubscribe
+	s.mu.Unlock()
+
+	if err := s.resync(eventID); err != nil {
+		log.WithError(err).WithField("event_id", eventID).Error("Failed to load event counters")
+		s.unsubscribeEvent(eventID)
+		s.fail("failed to load stats for event " + eventID)
+		return
+	}
+
+	go s.applyUpdates(eventID, updates)
+}
+
+// authorize checks with dws-event-service that the user organises the event
+func (s *session) authorize(eventID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	event, err := s.dc.eventClient.GetEvent(ctx, eventID)
+	if err != nil {
+		if errors.Is(err, eventclient.ErrEventNotFound) {
+			return errors.New("event " + eventID + " not found")
+		}
+		log.WithError(err).WithField("event_id", eventID).Error("Failed to fetch event")
+		return errors.New("could not validate event " + eventID + ", please try again")
+	}
+
+	if event.OrganizerID == "" || event.OrganizerID != s.userID {
+		log.WithFields(log.Fields{
+			"event_id": eventID,
+			"user_id":  s.userID,
+		}).Warn("Dashboard subscription to another organiser's event rejected")
+		return errors.New("not allowed to watch event " + eventID)
+	}
+	return nil
+}
+
+func (s *session) unsubscribeEvent(eventID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if unsubscribe, ok := s.unsubscribe[eventID]; ok {
+		unsubscribe()
+		delete(s.unsubscribe, eventID)
+		delete(s.counters, eventID)
+		delete(s.snapshotAt, eventID)
+		delete(s.dirty, eventID)
+	}
+}
+
+// applyUpdates folds status changes into the event's counters until the
+// subscription ends
+func (s *session) applyUpdates(eventID string, updates <-chan types.TicketStatusMessage) {
+	for msg := range updates {
+		s.mu.Lock()
+		counters, ok := s.counters[eventID]
+		// Changes older than the snapshot are already counted
+		if ok && msg.Timestamp.After(s.snapshotAt[eventID]) && counters.Apply(msg) {
+			s.dirty[eventID] = true
+			s.signal()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// resync replaces an event's counters with a fresh snapshot from the database
+func (s *session) resync(eventID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	snapshotAt := time.Now()
+	tickets, err := s.dc.dbService.Client.Ticket.FindMany(
+		db.Ticket.EventID.Equals(eventID),
+	).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	counters := &live.EventCounters{EventID: eventID, UpdatedAt: snapshotAt}
+	for _, ticket := range tickets {
+		counters.Count(ticket.Status, ticket.Quantity, ticket.TotalPrice)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.unsubscribe[eventID]; !ok {
+		// Unsubscribed meanwhile
+		return nil
+	}
+	s.counters[eventID] = counters
+	s.snapshotAt[eventID] = snapshotAt
+	s.dirty[eventID] = true
+	s.signal()
+	return nil
+}
+
+func (s *session) fail(message string) {
+	s.mu.Lock()
+	s.errors = append(s.errors, message)
+	s.signal()
+	s.mu.Unlock()
+}
+
+// signal wakes the writer; callers hold s.mu
+func (s *session) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *session) writeLoop() {
+	resync := time.NewTicker(resyncInterval)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-resync.C:
+			s.mu.Lock()
+			eventIDs := make([]string, 0, len(s.unsubscribe))
+			for eventID := range s.unsubscribe {
+				eventIDs = append(eventIDs, eventID)
+			}
+			s.mu.Unlock()
+			for _, eventID := range eventIDs {
+				if err := s.resync(eventID); err != nil {
+					log.WithError(err).WithField("event_id", eventID).Warn("Failed to resync event counters")
+				}
+			}
+		case <-s.notify:
+			if err := s.flush(); err != nil {
+				// The client is gone or too slow; closing ends the read loop
+				log.WithError(err).Info("Closing dashboard connection")
+				s.ws.Close()
+				return
+			}
+			// Throttle: further updates in the meantime are coalesced
+			select {
+			case <-s.done:
+				return
+			case <-time.After(flushInterval):
+			}
+		}
+	}
+}
+
+// flush sends pending errors and the counters of every changed event
+func (s *session) flush() error {
+	s.mu.Lock()
+	messages := make([]serverMessage, 0, len(s.errors)+len(s.dirty))
+	for _, message := range s.errors {
+		messages = append(messages, serverMessage{Type: "error", Message: message})
+	}
+	s.errors = nil
+	for eventID := range s.dirty {
+		if counters, ok := s.counters[eventID]; ok {
+			snapshot := *counters
+			messages = append(messages, serverMessage{Type: "stats", Stats: &snapshot})
+		}
+		delete(s.dirty, eventID)
+	}
+	s.mu.Unlock()
+
+	for _, msg := range messages {
+		if err := s.ws.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			return err
+		}
+		if err := websocket.JSON.Send(s.ws, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
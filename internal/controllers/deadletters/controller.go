@@ -0,0 +1,158 @@
+package deadletters
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultListLimit bounds how many dead-lettered messages are listed by default
+const defaultListLimit = 100
+
+// DeadLettersController lets operators inspect messages that failed for good,
+// replay them once the cause is fixed, or purge them
+type DeadLettersController struct {
+	deadLetters broker.DeadLetters
+}
+
+func NewDeadLettersController(deadLetters broker.DeadLetters) *DeadLettersController {
+	return &DeadLettersController{
+		deadLetters: deadLetters,
+	}
+}
+
+// ListDeadLetters handles GET /api/v1/admin/dead-letters/:queue
+func (dc *DeadLettersController) ListDeadLetters(c *gin.Context) {
+	limit := defaultListLimit
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, types.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "limit must be a positive number",
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	msgs, err := dc.deadLetters.DeadLetters(c.Param("queue"), limit)
+	if err != nil {
+		dc.fail(c, err, "Failed to read dead-lettered messages")
+		return
+	}
+
+	response := make([]types.DeadLetterMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		response = append(response, broker.DescribeDeadLetter(msg, false))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetDeadLetter handles GET /api/v1/admin/dead-letters/:queue/:messageId
+func (dc *DeadLettersController) GetDeadLetter(c *gin.Context) {
+	msgs, err := dc.deadLetters.DeadLetters(c.Param("queue"), 0)
+	if err != nil {
+		dc.fail(c, err, "Failed to read dead-lettered messages")
+		return
+	}
+
+	for _, msg := range msgs {
+		if msg.MessageID == c.Param("messageId") {
+			c.JSON(http.StatusOK, broker.DescribeDeadLetter(msg, true))
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, types.ErrorResponse{
+		Error:   "not_found",
+		Message: "Message not found in the dead-letter queue",
+	})
+}
+
+// ReplayDeadLetters handles POST /api/v1/admin/dead-letters/:queue/replay
+func (dc *DeadLettersController) ReplayDeadLetters(c *gin.Context) {
+	var req types.ReplayDeadLettersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	replayed, err := dc.deadLetters.ReplayDeadLetters(c.Param("queue"), req.MessageIDs)
+	if err != nil {
+		dc.fail(c, err, "Failed to replay dead-lettered messages")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"queue":       c.Param("queue"),
+		"message_ids": req.MessageIDs,
+		"replayed":    replayed,
+		"user_id":     c.GetString("user_id"),
+	}).Info("Replayed dead-lettered messages")
+	c.JSON(http.StatusOK, types.DeadLettersResponse{Count: replayed})
+}
+
+// PurgeDeadLetters handles POST /api/v1/admin/dead-letters/:queue/purge
+func (dc *DeadLettersController) PurgeDeadLetters(c *gin.Context) {
+	var req types.PurgeDeadLettersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+	if len(req.MessageIDs) == 0 && !req.All {
+		c.JSON(http.StatusBadRequest, types.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Select message_ids or set all to purge the whole queue",
+		})
+		return
+	}
+	if len(req.MessageIDs) > 0 && req.All {
+		c.JSON(http.StatusBadRequest, types.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "message_ids and all are mutually exclusive",
+		})
+		return
+	}
+
+	purged, err := dc.deadLetters.PurgeDeadLetters(c.Param("queue"), req.MessageIDs)
+	if err != nil {
+		dc.fail(c, err, "Failed to purge dead-lettered messages")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"queue":       c.Param("queue"),
+		"message_ids": req.MessageIDs,
+		"purged":      purged,
+		"user_id":     c.GetString("user_id"),
+	}).Warn("Purged dead-lettered messages")
+	c.JSON(http.StatusOK, types.DeadLettersResponse{Count: purged})
+}
+
+func (dc *DeadLettersController) fail(c *gin.Context, err error, message string) {
+	if errors.Is(err, broker.ErrUnknownQueue) {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Queue " + c.Param("queue") + " has no dead-letter queue",
+		})
+		return
+	}
+
+	log.WithError(err).WithField("queue", c.Param("queue")).Error(message)
+	c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+		Error:   "messaging_error",
+		Message: message,
+	})
+}
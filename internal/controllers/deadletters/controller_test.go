@@ -0,0 +1,130 @@
+package deadletters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const purchased = "ticket.purchased"
+
+// setup returns a router over an in-memory broker with one dead-lettered
+// purchase message
+func setup(t *testing.T) (*gin.Engine, *broker.Memory, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := &configs.Config{}
+	cfg.RabbitMQ.Exchange = "ticket_exchange"
+	cfg.RabbitMQ.DeadLetterExchange = "ticket_dlx"
+	cfg.RabbitMQ.Queue.Purchased = purchased
+	m := broker.NewMemory(cfg)
+	t.Cleanup(func() { m.Close() })
+
+	// The consumer fails the message for good
+	msgs, err := m.ConsumeTicketPurchased()
+	require.NoError(t, err)
+	require.NoError(t, m.PublishTicketPurchased(context.Background(), types.TicketMessage{TicketID: "t1"}))
+	msg := <-msgs
+	require.NoError(t, m.DeadLetter(purchased, msg, "ticket not found"))
+	require.NoError(t, msg.Ack())
+	require.NoError(t, m.CancelConsumers())
+
+	controller := NewDeadLettersController(m)
+	router := gin.New()
+	router.GET("/dead-letters/:queue", controller.ListDeadLetters)
+	router.GET("/dead-letters/:queue/:messageId", controller.GetDeadLetter)
+	router.POST("/dead-letters/:queue/replay", controller.ReplayDeadLetters)
+	router.POST("/dead-letters/:queue/purge", controller.PurgeDeadLetters)
+	return router, m, msg.MessageID
+}
+
+func do(router *gin.Engine, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestListDeadLetters(t *testing.T) {
+	router, _, messageID := setup(t)
+
+	w := do(router, http.MethodGet, "/dead-letters/"+purchased, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var msgs []types.DeadLetterMessage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &msgs))
+	require.Len(t, msgs, 1)
+	assert.Equal(t, messageID, msgs[0].MessageID)
+	assert.Equal(t, purchased, msgs[0].Queue)
+	assert.Equal(t, "ticket not found", msgs[0].Reason)
+	assert.NotNil(t, msgs[0].DeadLetteredAt)
+	assert.Empty(t, msgs[0].Body, "bodies are only shown for single messages")
+
+	w = do(router, http.MethodGet, "/dead-letters/"+purchased+"?limit=0", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(router, http.MethodGet, "/dead-letters/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetDeadLetter(t *testing.T) {
+	router, _, messageID := setup(t)
+
+	w := do(router, http.MethodGet, "/dead-letters/"+purchased+"/"+messageID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var msg types.DeadLetterMessage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &msg))
+	var body types.TicketMessage
+	require.NoError(t, json.Unmarshal(msg.Body, &body))
+	assert.Equal(t, "t1", body.TicketID)
+
+	w = do(router, http.MethodGet, "/dead-letters/"+purchased+"/missing", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	router, m, messageID := setup(t)
+
+	w := do(router, http.MethodPost, "/dead-letters/"+purchased+"/replay", types.ReplayDeadLettersRequest{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(router, http.MethodPost, "/dead-letters/"+purchased+"/replay", types.ReplayDeadLettersRequest{
+		MessageIDs: []string{messageID},
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"count":1}`, w.Body.String())
+
+	assert.Empty(t, m.Messages(broker.DeadLetterQueueName(purchased)))
+	replayed := m.Messages(purchased)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, messageID, replayed[0].MessageID)
+}
+
+func TestPurgeDeadLetters(t *testing.T) {
+	router, m, _ := setup(t)
+
+	w := do(router, http.MethodPost, "/dead-letters/"+purchased+"/purge", types.PurgeDeadLettersRequest{})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "purging everything must be explicit")
+
+	w = do(router, http.MethodPost, "/dead-letters/"+purchased+"/purge", types.PurgeDeadLettersRequest{All: true})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"count":1}`, w.Body.String())
+	assert.Empty(t, m.Messages(broker.DeadLetterQueueName(purchased)))
+}
@@ -6,17 +6,17 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/oskargbc/dws-ticket-service/internal/pkg/rabbitmq"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
 	"github.com/oskargbc/dws-ticket-service/internal/services"
 	"github.com/oskargbc/dws-ticket-service/internal/types"
 )
 
 type HealthController struct {
 	dbService       *services.DatabaseService
-	rabbitmqService *rabbitmq.RabbitMQService
+	rabbitmqService broker.Broker
 }
 
-func NewHealthController(db *services.DatabaseService, rmq *rabbitmq.RabbitMQService) *HealthController {
+func NewHealthController(db *services.DatabaseService, rmq broker.Broker) *HealthController {
 	return &HealthController{
 		dbService:       db,
 		rabbitmqService: rmq,
@@ -2,11 +2,18 @@ package tickets
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/oskargbc/dws-ticket-service/internal/pkg/rabbitmq"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/eventclient"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/ids"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/live"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/webhook"
 	"github.com/oskargbc/dws-ticket-service/internal/services"
 	"github.com/oskargbc/dws-ticket-service/internal/types"
 	"github.com/oskargbc/dws-ticket-service/prisma/db"
@@ -15,13 +22,23 @@ import (
 
 type TicketsController struct {
 	dbService       *services.DatabaseService
-	rabbitmqService *rabbitmq.RabbitMQService
+	rabbitmqService broker.Publisher
+	invoiceService  *services.InvoiceService
+	eventClient     *eventclient.Client
+	webhookService  *services.WebhookService
+	outboxService   *services.OutboxService
+	statusHub       *live.Hub
 }
 
-func NewTicketsController(dbSvc *services.DatabaseService, rmqSvc *rabbitmq.RabbitMQService) *TicketsController {
+func NewTicketsController(dbSvc *services.DatabaseService, rmqSvc broker.Publisher, invoiceSvc *services.InvoiceService, eventClient *eventclient.Client, webhookSvc *services.WebhookService, outboxSvc *services.OutboxService, statusHub *live.Hub) *TicketsController {
 	return &TicketsController{
 		dbService:       dbSvc,
 		rabbitmqService: rmqSvc,
+		invoiceService:  invoiceSvc,
+		eventClient:     eventClient,
+		webhookService:  webhookSvc,
+		outboxService:   outboxSvc,
+		statusHub:       statusHub,
 	}
 }
 
@@ -48,16 +65,57 @@ func (tc *TicketsController) PurchaseTicket(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 	defer cancel()
 
+	event, ok := tc.eventOnSale(ctx, c, req.EventID)
+	if !ok {
+		return
+	}
+
+	// The event service is authoritative for the organiser. Without one the
+	// ticket is invoiced by the default seller and triggers no webhooks; the
+	// buyer can't choose an organiser.
+	organizerID := event.OrganizerID
+
+	// The purchased message is written to the outbox in the same transaction
+	// as the ticket, so a ticket is never left pending without one
+	ticketID := ids.NewUUID()
+	msg := types.TicketMessage{
+		TicketID:      ticketID,
+		UserID:        userID.(string),
+		EventID:       req.EventID,
+		Quantity:      req.Quantity,
+		TotalPrice:    req.TotalPrice,
+		Timestamp:     time.Now(),
+		PaymentMethod: req.PaymentMethod,
+	}
+
+	outboxMsg, err := tc.outboxService.TicketPurchased(msg)
+	if err != nil {
+		log.WithError(err).Error("Failed to build ticket purchased message")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to create ticket",
+		})
+		return
+	}
+
 	// Create ticket with pending status
-	ticket, err := tc.dbService.Client.Ticket.CreateOne(
+	createTicket := tc.dbService.Client.Ticket.CreateOne(
 		db.Ticket.UserID.Set(userID.(string)),
 		db.Ticket.EventID.Set(req.EventID),
 		db.Ticket.Quantity.Set(req.Quantity),
 		db.Ticket.TotalPrice.Set(req.TotalPrice),
+		db.Ticket.ID.Set(ticketID),
 		db.Ticket.Status.Set("pending"),
-	).Exec(ctx)
-
-	if err != nil {
+		db.Ticket.OrganizerID.SetIfPresent(optionalString(organizerID)),
+		db.Ticket.BuyerName.SetIfPresent(optionalString(c.GetString("user_name"))),
+		db.Ticket.BuyerEmail.SetIfPresent(optionalString(c.GetString("user_email"))),
+		db.Ticket.EventName.SetIfPresent(optionalString(event.Name)),
+		db.Ticket.EventStartsAt.SetIfPresent(optionalTime(event.StartDate)),
+		db.Ticket.EventEndsAt.SetIfPresent(optionalTime(event.EndDate)),
+		db.Ticket.EventLocation.SetIfPresent(optionalString(event.Location)),
+	).Tx()
+
+	if err := tc.dbService.Client.Prisma.Transaction(createTicket, outboxMsg).Exec(ctx); err != nil {
 		log.WithError(err).Error("Failed to create ticket")
 		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
 			Error:   "database_error",
@@ -66,24 +124,57 @@ func (tc *TicketsController) PurchaseTicket(c *gin.Context) {
 		return
 	}
 
-	// Publish message to RabbitMQ
-	msg := types.TicketMessage{
-		TicketID:   ticket.ID,
-		UserID:     ticket.UserID,
-		EventID:    ticket.EventID,
-		Quantity:   ticket.Quantity,
-		TotalPrice: ticket.TotalPrice,
-		Timestamp:  time.Now(),
-	}
+	tc.outboxService.Notify()
+	ticket := createTicket.Result()
 
-	if err := tc.rabbitmqService.PublishTicketPurchased(msg); err != nil {
-		log.WithError(err).Error("Failed to publish message to RabbitMQ")
-		// Don't fail the request, ticket is already created
+	if err := tc.webhookService.Enqueue(ctx, webhook.EventTicketPurchased, ticket); err != nil {
+		log.WithError(err).Error("Failed to enqueue purchase webhook")
 	}
 
+	tc.publishStatus(ctx, ticket, "")
+
 	c.JSON(http.StatusCreated, mapTicketToResponse(ticket))
 }
 
+// eventOnSale validates the event against dws-event-service and writes the
+// error response unless tickets can currently be paid for
+func (tc *TicketsController) eventOnSale(ctx context.Context, c *gin.Context, eventID string) (*eventclient.Event, bool) {
+	event, err := tc.eventClient.GetEvent(ctx, eventID)
+	if err != nil {
+		if errors.Is(err, eventclient.ErrEventNotFound) {
+			c.JSON(http.StatusNotFound, types.ErrorResponse{
+				Error:   "event_not_found",
+				Message: "Event not found",
+			})
+			return nil, false
+		}
+		log.WithError(err).WithField("event_id", eventID).Error("Failed to fetch event")
+		c.JSON(http.StatusServiceUnavailable, types.ErrorResponse{
+			Error:   "event_service_unavailable",
+			Message: "Could not validate event, please try again",
+		})
+		return nil, false
+	}
+
+	if event.HasEnded(time.Now()) {
+		c.JSON(http.StatusConflict, types.ErrorResponse{
+			Error:   "event_ended",
+			Message: "Event is already over",
+		})
+		return nil, false
+	}
+
+	if !event.OnSale() {
+		c.JSON(http.StatusConflict, types.ErrorResponse{
+			Error:   "event_not_on_sale",
+			Message: fmt.Sprintf("Tickets are not on sale for this event (%s)", event.SaleStatus),
+		})
+		return nil, false
+	}
+
+	return event, true
+}
+
 // GetMyTickets handles GET /api/v1/tickets/my-tickets
 func (tc *TicketsController) GetMyTickets(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -230,15 +321,77 @@ func (tc *TicketsController) CancelTicket(c *gin.Context) {
 		return
 	}
 
-	// Update ticket status to cancelled
+	// Failed, expired and refunded tickets hold no seat and no payment
+	if ticket.Status != "pending" && ticket.Status != "confirmed" {
+		c.JSON(http.StatusConflict, types.ErrorResponse{
+			Error:   "invalid_status",
+			Message: fmt.Sprintf("Only pending or confirmed tickets can be cancelled, ticket is %s", ticket.Status),
+		})
+		return
+	}
+
+	outboxMsg, err := tc.outboxService.TicketNotificationIfStatus(types.TicketNotificationMessage{
+		Type:      types.NotificationTicketCancelled,
+		TicketID:  ticket.ID,
+		UserID:    ticket.UserID,
+		EventID:   ticket.EventID,
+		Status:    "cancelled",
+		Timestamp: time.Now(),
+	}, ticket.Status)
+	if err != nil {
+		log.WithError(err).Error("Failed to build cancellation notification")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to cancel ticket",
+		})
+		return
+	}
+
+	// Update ticket status to cancelled, together with the notification. Both
+	// only apply if the status is still the one read above. The
+	// payment of a confirmed ticket is recorded as owed and refunded by the
+	// consumer's sweeper; a pending ticket's payment in flight is refunded by
+	// the consumer once it sees the cancellation.
+	update := []db.TicketSetParam{
+		db.Ticket.Status.Set("cancelled"),
+	}
+	if paymentID, ok := ticket.PaymentID(); ok && ticket.Status == "confirmed" {
+		update = append(update,
+			db.Ticket.OwedPaymentID.Set(paymentID),
+			db.Ticket.RefundStatus.Set("due"),
+		)
+	}
+
+	cancelTicket := tc.dbService.Client.Ticket.FindMany(
+		db.Ticket.ID.Equals(ticketID),
+		db.Ticket.Status.Equals(ticket.Status),
+	).Update(update...).Tx()
+
+	if err := tc.dbService.Client.Prisma.Transaction(outboxMsg, cancelTicket).Exec(ctx); err != nil {
+		log.WithError(err).Error("Failed to cancel ticket")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to cancel ticket",
+		})
+		return
+	}
+
+	if cancelTicket.Result().Count == 0 {
+		c.JSON(http.StatusConflict, types.ErrorResponse{
+			Error:   "invalid_status",
+			Message: "Ticket changed while cancelling, please try again",
+		})
+		return
+	}
+
+	tc.outboxService.Notify()
+
 	updatedTicket, err := tc.dbService.Client.Ticket.FindUnique(
 		db.Ticket.ID.Equals(ticketID),
-	).Update(
-		db.Ticket.Status.Set("cancelled"),
 	).Exec(ctx)
 
 	if err != nil {
-		log.WithError(err).Error("Failed to cancel ticket")
+		log.WithError(err).Error("Failed to reload cancelled ticket")
 		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
 			Error:   "database_error",
 			Message: "Failed to cancel ticket",
@@ -246,19 +399,335 @@ func (tc *TicketsController) CancelTicket(c *gin.Context) {
 		return
 	}
 
+	if err := tc.webhookService.Enqueue(ctx, webhook.EventTicketCancelled, updatedTicket); err != nil {
+		log.WithError(err).Error("Failed to enqueue cancellation webhook")
+	}
+
+	tc.publishStatus(ctx, updatedTicket, ticket.Status)
+
 	c.JSON(http.StatusOK, mapTicketToResponse(updatedTicket))
 }
 
+// RetryPayment handles POST /api/v1/tickets/:id/retry-payment
+func (tc *TicketsController) RetryPayment(c *gin.Context) {
+	ticketID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	var req types.RetryPaymentRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.ErrorResponse{
+				Error:   "invalid_request",
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	ticket, err := tc.dbService.Client.Ticket.FindUnique(
+		db.Ticket.ID.Equals(ticketID),
+	).Exec(ctx)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Ticket not found",
+		})
+		return
+	}
+
+	if ticket.UserID != userID.(string) {
+		c.JSON(http.StatusForbidden, types.ErrorResponse{
+			Error:   "forbidden",
+			Message: "You don't have permission to pay for this ticket",
+		})
+		return
+	}
+
+	// A cancelled or ended event must not be charged for; its tickets are
+	// cancelled by the event lifecycle consumer as well
+	if _, ok := tc.eventOnSale(ctx, c, ticket.EventID); !ok {
+		return
+	}
+
+	nextAttempt := ticket.PaymentAttempt + 1
+	msg := types.TicketMessage{
+		TicketID:       ticket.ID,
+		UserID:         ticket.UserID,
+		EventID:        ticket.EventID,
+		Quantity:       ticket.Quantity,
+		TotalPrice:     ticket.TotalPrice,
+		Timestamp:      time.Now(),
+		PaymentMethod:  req.PaymentMethod,
+		PaymentAttempt: nextAttempt,
+	}
+
+	outboxMsg, err := tc.outboxService.PaymentRetried(msg)
+	if err != nil {
+		log.WithError(err).Error("Failed to build payment retry message")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to retry payment",
+		})
+		return
+	}
+
+	// Only move the ticket back to pending if it is still failed or expired
+	// with the attempt read above, so concurrent retries can't start two
+	// payment attempts. The message is only written if this update matched.
+	retry := tc.dbService.Client.Ticket.FindMany(
+		db.Ticket.ID.Equals(ticketID),
+		db.Ticket.Status.In([]string{"payment_failed", "expired"}),
+		db.Ticket.PaymentAttempt.Equals(ticket.PaymentAttempt),
+	).Update(
+		db.Ticket.Status.Set("pending"),
+		db.Ticket.PaymentAttempt.Set(nextAttempt),
+		db.Ticket.RecoveryCount.Set(0),
+		db.Ticket.FailureReason.SetOptional(nil),
+	).Tx()
+
+	if err := tc.dbService.Client.Prisma.Transaction(retry, outboxMsg).Exec(ctx); err != nil {
+		log.WithError(err).Error("Failed to reset ticket for payment retry")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to retry payment",
+		})
+		return
+	}
+
+	if retry.Result().Count == 0 {
+		c.JSON(http.StatusConflict, types.ErrorResponse{
+			Error:   "invalid_status",
+			Message: fmt.Sprintf("Payment can only be retried for failed payments, ticket is %s", ticket.Status),
+		})
+		return
+	}
+
+	tc.outboxService.Notify()
+
+	updatedTicket, err := tc.dbService.Client.Ticket.FindUnique(
+		db.Ticket.ID.Equals(ticketID),
+	).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to reload ticket after payment retry")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to retry payment",
+		})
+		return
+	}
+
+	tc.publishStatus(ctx, updatedTicket, ticket.Status)
+
+	c.JSON(http.StatusAccepted, mapTicketToResponse(updatedTicket))
+}
+
+// StreamTicketEvents handles GET /api/v1/tickets/:id/events. It streams the
+// ticket's status as Server-Sent Events: the current status first, then every
+// change until the client disconnects.
+func (tc *TicketsController) StreamTicketEvents(c *gin.Context) {
+	ticketID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	// Subscribe before reading the current status so no change is missed
+	updates, unsubscribe := tc.statusHub.Subscribe(ticketID)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	ticket, err := tc.dbService.Client.Ticket.FindUnique(
+		db.Ticket.ID.Equals(ticketID),
+	).Exec(ctx)
+	cancel()
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Ticket not found",
+		})
+		return
+	}
+
+	if ticket.UserID != userID.(string) {
+		c.JSON(http.StatusForbidden, types.ErrorResponse{
+			Error:   "forbidden",
+			Message: "You don't have permission to view this ticket",
+		})
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.WithError(err).Debug("Failed to clear write deadline for event stream")
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	last := ticketStatusMessage(ticket, "")
+	c.SSEvent("status", last)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case msg, ok := <-updates:
+			if !ok {
+				return false
+			}
+			// Updates can arrive out of order or duplicated across replicas
+			if msg.Status == last.Status || msg.Timestamp.Before(last.Timestamp) {
+				return true
+			}
+			last = msg
+			c.SSEvent("status", msg)
+			return true
+		case <-heartbeat.C:
+			// Comment line keeps proxies from closing an idle stream
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		}
+	})
+}
+
+// publishStatus announces a status change to live streams on all replicas.
+// Streams are a convenience on top of polling, so failures are only logged.
+func (tc *TicketsController) publishStatus(ctx context.Context, ticket *db.TicketModel, previousStatus string) {
+	if err := tc.rabbitmqService.PublishTicketStatus(ctx, ticketStatusMessage(ticket, previousStatus)); err != nil {
+		log.WithError(err).WithField("ticket_id", ticket.ID).Warn("Failed to publish ticket status")
+	}
+}
+
+func ticketStatusMessage(ticket *db.TicketModel, previousStatus string) types.TicketStatusMessage {
+	failureReason, _ := ticket.FailureReason()
+	return types.TicketStatusMessage{
+		TicketID:       ticket.ID,
+		UserID:         ticket.UserID,
+		EventID:        ticket.EventID,
+		Status:         ticket.Status,
+		PreviousStatus: previousStatus,
+		FailureReason:  failureReason,
+		Quantity:       ticket.Quantity,
+		TotalPrice:     ticket.TotalPrice,
+		Timestamp:      ticket.UpdatedAt,
+	}
+}
+
+// GetInvoice handles GET /api/v1/tickets/:id/invoice
+func (tc *TicketsController) GetInvoice(c *gin.Context) {
+	ticketID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	ticket, err := tc.dbService.Client.Ticket.FindUnique(
+		db.Ticket.ID.Equals(ticketID),
+	).Exec(ctx)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Ticket not found",
+		})
+		return
+	}
+
+	if ticket.UserID != userID.(string) {
+		c.JSON(http.StatusForbidden, types.ErrorResponse{
+			Error:   "forbidden",
+			Message: "You don't have permission to view this invoice",
+		})
+		return
+	}
+
+	invoice, err := tc.dbService.Client.Invoice.FindUnique(
+		db.Invoice.TicketID.Equals(ticketID),
+	).Exec(ctx)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Invoice not available yet, it is issued once the ticket is confirmed",
+		})
+		return
+	}
+
+	pdf, err := tc.invoiceService.PDF(ctx, invoice)
+	if pdf == nil {
+		log.WithError(err).Error("Failed to render invoice")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to load invoice",
+		})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Warn("Serving invoice PDF that could not be stored")
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.Number))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// optionalString maps an empty string to nil for optional Prisma fields
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// optionalTime maps a zero time to nil for optional Prisma fields
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
 func mapTicketToResponse(ticket *db.TicketModel) types.TicketResponse {
+	failureReason, _ := ticket.FailureReason()
 	return types.TicketResponse{
-		ID:         ticket.ID,
-		UserID:     ticket.UserID,
-		EventID:    ticket.EventID,
-		Quantity:   ticket.Quantity,
-		TotalPrice: ticket.TotalPrice,
-		Status:     ticket.Status,
-		CreatedAt:  ticket.CreatedAt,
-		UpdatedAt:  ticket.UpdatedAt,
+		ID:            ticket.ID,
+		UserID:        ticket.UserID,
+		EventID:       ticket.EventID,
+		Quantity:      ticket.Quantity,
+		TotalPrice:    ticket.TotalPrice,
+		Status:        ticket.Status,
+		FailureReason: failureReason,
+		CreatedAt:     ticket.CreatedAt,
+		UpdatedAt:     ticket.UpdatedAt,
 	}
 }
 
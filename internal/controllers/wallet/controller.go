@@ -0,0 +1,123 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/wallet"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+type WalletController struct {
+	dbService     *services.DatabaseService
+	walletService *services.WalletService
+}
+
+func NewWalletController(dbSvc *services.DatabaseService, walletSvc *services.WalletService) *WalletController {
+	return &WalletController{
+		dbService:     dbSvc,
+		walletService: walletSvc,
+	}
+}
+
+// GetApplePass handles GET /api/v1/tickets/:id/wallet/apple
+func (wc *WalletController) GetApplePass(c *gin.Context) {
+	ticket, ok := wc.confirmedTicket(c)
+	if !ok {
+		return
+	}
+
+	pass, err := wc.walletService.ApplePass(ticket)
+	if err != nil {
+		respondWalletError(c, "Apple Wallet", err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="ticket-%s.pkpass"`, ticket.ID))
+	c.Data(http.StatusOK, wallet.ContentTypePKPass, pass)
+}
+
+// GetGoogleSaveLink handles GET /api/v1/tickets/:id/wallet/google
+func (wc *WalletController) GetGoogleSaveLink(c *gin.Context) {
+	ticket, ok := wc.confirmedTicket(c)
+	if !ok {
+		return
+	}
+
+	link, err := wc.walletService.GoogleSaveLink(ticket)
+	if err != nil {
+		respondWalletError(c, "Google Wallet", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.GoogleWalletResponse{SaveURL: link})
+}
+
+// confirmedTicket loads the ticket in the :id param, writing the error
+// response unless it is owned by the caller and confirmed
+func (wc *WalletController) confirmedTicket(c *gin.Context) (*db.TicketModel, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	ticket, err := wc.dbService.Client.Ticket.FindUnique(
+		db.Ticket.ID.Equals(c.Param("id")),
+	).Exec(ctx)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Ticket not found",
+		})
+		return nil, false
+	}
+
+	if ticket.UserID != userID.(string) {
+		c.JSON(http.StatusForbidden, types.ErrorResponse{
+			Error:   "forbidden",
+			Message: "You don't have permission to view this ticket",
+		})
+		return nil, false
+	}
+
+	if ticket.Status != "confirmed" {
+		c.JSON(http.StatusConflict, types.ErrorResponse{
+			Error:   "invalid_status",
+			Message: fmt.Sprintf("Wallet passes are only available for confirmed tickets, ticket is %s", ticket.Status),
+		})
+		return nil, false
+	}
+
+	return ticket, true
+}
+
+func respondWalletError(c *gin.Context, provider string, err error) {
+	if errors.Is(err, wallet.ErrNotConfigured) {
+		c.JSON(http.StatusServiceUnavailable, types.ErrorResponse{
+			Error:   "wallet_unavailable",
+			Message: provider + " passes are not available",
+		})
+		return
+	}
+
+	log.WithError(err).WithField("wallet", provider).Error("Failed to create wallet pass")
+	c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+		Error:   "internal_error",
+		Message: "Failed to create " + provider + " pass",
+	})
+}
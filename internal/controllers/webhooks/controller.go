@@ -0,0 +1,300 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/webhook"
+	"github.com/oskargbc/dws-ticket-service/internal/services"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// WebhooksController manages an organiser's webhook subscriptions. The
+// organiser is identified by the authenticated user's ID.
+type WebhooksController struct {
+	dbService      *services.DatabaseService
+	webhookService *services.WebhookService
+}
+
+func NewWebhooksController(dbSvc *services.DatabaseService, webhookSvc *services.WebhookService) *WebhooksController {
+	return &WebhooksController{
+		dbService:      dbSvc,
+		webhookService: webhookSvc,
+	}
+}
+
+// CreateWebhook handles POST /api/v1/webhooks
+func (wc *WebhooksController) CreateWebhook(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	var req types.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := webhook.ValidURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, types.ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+	for _, t := range req.EventTypes {
+		if !webhook.ValidEventType(t) {
+			c.JSON(http.StatusBadRequest, types.ErrorResponse{
+				Error:   "invalid_event_type",
+				Message: "Unknown event type " + t + ", expected one of " + strings.Join(webhook.EventTypes, ", "),
+			})
+			return
+		}
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		log.WithError(err).Error("Failed to generate webhook secret")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to create webhook",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	sub, err := wc.dbService.Client.WebhookSubscription.CreateOne(
+		db.WebhookSubscription.OrganizerID.Set(userID.(string)),
+		db.WebhookSubscription.URL.Set(req.URL),
+		db.WebhookSubscription.Secret.Set(secret),
+		db.WebhookSubscription.EventTypes.Set(strings.Join(req.EventTypes, ",")),
+	).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to create webhook subscription")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to create webhook",
+		})
+		return
+	}
+
+	// The secret is only ever returned here
+	resp := mapSubscriptionToResponse(sub)
+	resp.Secret = sub.Secret
+	c.JSON(http.StatusCreated, resp)
+}
+
+// ListWebhooks handles GET /api/v1/webhooks
+func (wc *WebhooksController) ListWebhooks(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	subs, err := wc.dbService.Client.WebhookSubscription.FindMany(
+		db.WebhookSubscription.OrganizerID.Equals(userID.(string)),
+	).OrderBy(
+		db.WebhookSubscription.CreatedAt.Order(db.DESC),
+	).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch webhook subscriptions")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to fetch webhooks",
+		})
+		return
+	}
+
+	response := make([]types.WebhookResponse, len(subs))
+	for i := range subs {
+		response[i] = mapSubscriptionToResponse(&subs[i])
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteWebhook handles DELETE /api/v1/webhooks/:id. Pending deliveries of
+// the subscription are failed by the worker.
+func (wc *WebhooksController) DeleteWebhook(c *gin.Context) {
+	sub, ok := wc.ownedSubscription(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, err := wc.dbService.Client.WebhookSubscription.FindUnique(
+		db.WebhookSubscription.ID.Equals(sub.ID),
+	).Delete().Exec(ctx); err != nil {
+		log.WithError(err).Error("Failed to delete webhook subscription")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to delete webhook",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries handles GET /api/v1/webhooks/:id/deliveries
+func (wc *WebhooksController) ListDeliveries(c *gin.Context) {
+	sub, ok := wc.ownedSubscription(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	deliveries, err := wc.dbService.Client.WebhookDelivery.FindMany(
+		db.WebhookDelivery.SubscriptionID.Equals(sub.ID),
+	).OrderBy(
+		db.WebhookDelivery.CreatedAt.Order(db.DESC),
+	).Take(100).Exec(ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch webhook deliveries")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to fetch deliveries",
+		})
+		return
+	}
+
+	response := make([]types.WebhookDeliveryResponse, len(deliveries))
+	for i := range deliveries {
+		response[i] = mapDeliveryToResponse(&deliveries[i])
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RedeliverWebhook handles POST /api/v1/webhooks/deliveries/:id/redeliver
+func (wc *WebhooksController) RedeliverWebhook(c *gin.Context) {
+	deliveryID := c.Param("id")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	delivery, err := wc.dbService.Client.WebhookDelivery.FindUnique(
+		db.WebhookDelivery.ID.Equals(deliveryID),
+	).Exec(ctx)
+
+	if err != nil || delivery.OrganizerID != userID.(string) {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Webhook delivery not found",
+		})
+		return
+	}
+
+	updated, err := wc.webhookService.Redeliver(ctx, delivery.ID)
+	if err != nil {
+		log.WithError(err).Error("Failed to schedule webhook redelivery")
+		c.JSON(http.StatusInternalServerError, types.ErrorResponse{
+			Error:   "database_error",
+			Message: "Failed to schedule redelivery",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, mapDeliveryToResponse(updated))
+}
+
+// ownedSubscription loads the subscription in the :id param and checks that
+// it belongs to the caller, writing the error response if not
+func (wc *WebhooksController) ownedSubscription(c *gin.Context) (*db.WebhookSubscriptionModel, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.ErrorResponse{
+			Error:   "unauthorized",
+			Message: "User ID not found in context",
+		})
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	sub, err := wc.dbService.Client.WebhookSubscription.FindUnique(
+		db.WebhookSubscription.ID.Equals(c.Param("id")),
+	).Exec(ctx)
+
+	// Don't reveal other organisers' subscriptions
+	if err != nil || sub.OrganizerID != userID.(string) {
+		c.JSON(http.StatusNotFound, types.ErrorResponse{
+			Error:   "not_found",
+			Message: "Webhook not found",
+		})
+		return nil, false
+	}
+
+	return sub, true
+}
+
+func mapSubscriptionToResponse(sub *db.WebhookSubscriptionModel) types.WebhookResponse {
+	return types.WebhookResponse{
+		ID:         sub.ID,
+		URL:        sub.URL,
+		EventTypes: services.SplitEventTypes(sub.EventTypes),
+		Active:     sub.Active,
+		CreatedAt:  sub.CreatedAt,
+	}
+}
+
+func mapDeliveryToResponse(delivery *db.WebhookDeliveryModel) types.WebhookDeliveryResponse {
+	statusCode, _ := delivery.LastStatusCode()
+	lastError, _ := delivery.LastError()
+	var deliveredAt *time.Time
+	if t, ok := delivery.DeliveredAt(); ok {
+		deliveredAt = &t
+	}
+
+	return types.WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		SubscriptionID: delivery.SubscriptionID,
+		EventType:      delivery.EventType,
+		TicketID:       delivery.TicketID,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		LastStatusCode: statusCode,
+		LastError:      lastError,
+		DeliveredAt:    deliveredAt,
+		CreatedAt:      delivery.CreatedAt,
+	}
+}
@@ -1,50 +1,82 @@
 package middlewares
 
 import (
-	"crypto/rsa"
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"fmt"
-	"math/big"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/jwks"
 	log "github.com/sirupsen/logrus"
 )
 
-type KeycloakCerts struct {
-	Keys []struct {
-		Kid string   `json:"kid"`
-		Kty string   `json:"kty"`
-		Alg string   `json:"alg"`
-		Use string   `json:"use"`
-		N   string   `json:"n"`
-		E   string   `json:"e"`
-		X5c []string `json:"x5c"`
-	} `json:"keys"`
-}
+var (
+	keyCachesMu sync.Mutex
+	keyCaches   = make(map[string]*jwks.Cache)
+)
 
-var publicKeys = make(map[string]*rsa.PublicKey)
+// keycloakKeys returns the signing key cache of the realm. The middleware is
+// built once per route group, so they share one cache and one refresh loop.
+func keycloakKeys(cfg *configs.Config) *jwks.Cache {
+	certsURL := cfg.Keycloak.CertsURL()
 
-func KeycloakAuthMiddleware(cfg *configs.Config) gin.HandlerFunc {
-	// Load public keys from Keycloak on startup
-	if err := loadKeycloakPublicKeys(cfg); err != nil {
-		log.WithError(err).Warn("Failed to load Keycloak public keys")
+	keyCachesMu.Lock()
+	defer keyCachesMu.Unlock()
+
+	if cache, ok := keyCaches[certsURL]; ok {
+		return cache
 	}
 
+	cache := jwks.New(jwks.Options{
+		URL:                certsURL,
+		DefaultTTL:         time.Duration(cfg.Keycloak.KeysTTLSeconds) * time.Second,
+		MinTTL:             time.Duration(cfg.Keycloak.KeysMinTTLSeconds) * time.Second,
+		MaxTTL:             time.Duration(cfg.Keycloak.KeysMaxTTLSeconds) * time.Second,
+		MinRefreshInterval: time.Duration(cfg.Keycloak.KeysRefreshIntervalSeconds) * time.Second,
+		UnknownKeyTTL:      time.Duration(cfg.Keycloak.UnknownKeyTTLSeconds) * time.Second,
+	})
+	// Loads the keys on startup and keeps them fresh for the process lifetime
+	go cache.Run(context.Background())
+	keyCaches[certsURL] = cache
+	return cache
+}
+
+func KeycloakAuthMiddleware(cfg *configs.Config) gin.HandlerFunc {
+	return keycloakAuth(cfg, false)
+}
+
+// KeycloakStreamAuthMiddleware is KeycloakAuthMiddleware for streaming
+// endpoints. Browsers can't set headers on EventSource and WebSocket
+// connections, so the token is also accepted as the access_token query
+// parameter. Only use it for those routes: query strings end up in access
+// logs and proxies.
+func KeycloakStreamAuthMiddleware(cfg *configs.Config) gin.HandlerFunc {
+	return keycloakAuth(cfg, true)
+}
+
+func keycloakAuth(cfg *configs.Config, allowQueryToken bool) gin.HandlerFunc {
+	keys := keycloakKeys(cfg)
+
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if authHeader == "" && allowQueryToken {
+			tokenString = c.Query("access_token")
+			// Keep the token out of anything logging the URL further down
+			query := c.Request.URL.Query()
+			query.Del("access_token")
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
 			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
+		} else if tokenString == authHeader {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format"})
 			c.Abort()
 			return
@@ -60,19 +92,7 @@ func KeycloakAuthMiddleware(cfg *configs.Config) gin.HandlerFunc {
 				return nil, fmt.Errorf("kid not found in token header")
 			}
 
-			publicKey, exists := publicKeys[kid]
-			if !exists {
-				// Try to reload keys if not found
-				if err := loadKeycloakPublicKeys(cfg); err != nil {
-					return nil, fmt.Errorf("failed to reload keys: %w", err)
-				}
-				publicKey, exists = publicKeys[kid]
-				if !exists {
-					return nil, fmt.Errorf("public key not found for kid: %s", kid)
-				}
-			}
-
-			return publicKey, nil
+			return keys.Key(c.Request.Context(), kid)
 		})
 
 		if err != nil || !token.Valid {
@@ -112,57 +132,16 @@ func KeycloakAuthMiddleware(cfg *configs.Config) gin.HandlerFunc {
 		// Store user ID and roles in context
 		c.Set("user_id", userID)
 		c.Set("user_roles", roles)
-		c.Next()
-	}
-}
-
-func loadKeycloakPublicKeys(cfg *configs.Config) error {
-	certsURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/certs", cfg.Keycloak.URL, cfg.Keycloak.Realm)
-	
-	resp, err := http.Get(certsURL)
-	if err != nil {
-		return fmt.Errorf("failed to fetch certs: %w", err)
-	}
-	defer resp.Body.Close()
-
-	var certs KeycloakCerts
-	if err := json.NewDecoder(resp.Body).Decode(&certs); err != nil {
-		return fmt.Errorf("failed to decode certs: %w", err)
-	}
 
-	for _, key := range certs.Keys {
-		if key.Kty == "RSA" {
-			publicKey, err := parseRSAPublicKey(key.N, key.E)
-			if err != nil {
-				log.WithError(err).WithField("kid", key.Kid).Warn("Failed to parse public key")
-				continue
-			}
-			publicKeys[key.Kid] = publicKey
+		// Profile claims are optional and used for receipts and notifications
+		if email, ok := claims["email"].(string); ok {
+			c.Set("user_email", email)
 		}
+		if name, ok := claims["name"].(string); ok {
+			c.Set("user_name", name)
+		}
+		c.Next()
 	}
-
-	log.WithField("count", len(publicKeys)).Info("Loaded Keycloak public keys")
-	return nil
-}
-
-func parseRSAPublicKey(nStr, eStr string) (*rsa.PublicKey, error) {
-	nBytes, err := base64.RawURLEncoding.DecodeString(nStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode n: %w", err)
-	}
-
-	eBytes, err := base64.RawURLEncoding.DecodeString(eStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode e: %w", err)
-	}
-
-	n := new(big.Int).SetBytes(nBytes)
-	e := new(big.Int).SetBytes(eBytes)
-
-	return &rsa.PublicKey{
-		N: n,
-		E: int(e.Int64()),
-	}, nil
 }
 
 // RequireRole ensures that the authenticated user has the given realm role
@@ -0,0 +1,101 @@
+// Package admin serves the operational endpoints of processes without a
+// public API: Prometheus metrics, liveness and readiness.
+package admin
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// checkTimeout bounds each readiness check
+const checkTimeout = 5 * time.Second
+
+// Check reports whether a dependency is usable
+type Check func(ctx context.Context) error
+
+// Server holds the state behind the admin endpoints
+type Server struct {
+	checks   map[string]Check
+	draining atomic.Bool
+}
+
+// NewServer creates an admin server whose readiness depends on the named checks
+func NewServer(checks map[string]Check) *Server {
+	return &Server{checks: checks}
+}
+
+// SetDraining marks the process as shutting down; it stops being ready so no
+// new work is routed to it while in-flight work finishes
+func (s *Server) SetDraining() {
+	s.draining.Store(true)
+}
+
+// Handler returns the admin routes:
+//   - GET /metrics       Prometheus metrics
+//   - GET /health/live   the process is running
+//   - GET /health/ready  every check passes and the process isn't draining
+func (s *Server) Handler() http.Handler {
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/health/live", s.live)
+	router.GET("/health/ready", s.ready)
+
+	return router
+}
+
+func (s *Server) live(c *gin.Context) {
+	c.JSON(http.StatusOK, types.HealthResponse{
+		Status: "healthy",
+	})
+}
+
+func (s *Server) ready(c *gin.Context) {
+	services := make(map[string]string, len(s.checks))
+	healthy := true
+
+	names := make([]string, 0, len(s.checks))
+	for name := range s.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), checkTimeout)
+		err := s.checks[name](ctx)
+		cancel()
+
+		if err != nil {
+			services[name] = err.Error()
+			healthy = false
+		} else {
+			services[name] = "connected"
+		}
+	}
+
+	if s.draining.Load() {
+		services["shutdown"] = "draining"
+		healthy = false
+	}
+
+	if !healthy {
+		c.JSON(http.StatusServiceUnavailable, types.HealthResponse{
+			Status:   "unhealthy",
+			Services: services,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.HealthResponse{
+		Status:   "healthy",
+		Services: services,
+	})
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, s *Server, path string) (int, types.HealthResponse) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+
+	var resp types.HealthResponse
+	if path != "/metrics" {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w.Code, resp
+}
+
+func passing(context.Context) error { return nil }
+
+func TestLive(t *testing.T) {
+	s := NewServer(map[string]Check{
+		"database": func(context.Context) error { return errors.New("down") },
+	})
+
+	// Liveness doesn't depend on the checks, a restart wouldn't fix them
+	code, resp := get(t, s, "/health/live")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "healthy", resp.Status)
+}
+
+func TestReady(t *testing.T) {
+	s := NewServer(map[string]Check{"database": passing, "broker": passing})
+
+	code, resp := get(t, s, "/health/ready")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"database": "connected", "broker": "connected"}, resp.Services)
+}
+
+func TestReadyFailsWhenACheckFails(t *testing.T) {
+	s := NewServer(map[string]Check{
+		"database": passing,
+		"broker":   func(context.Context) error { return errors.New("channel closed") },
+	})
+
+	code, resp := get(t, s, "/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unhealthy", resp.Status)
+	assert.Equal(t, "channel closed", resp.Services["broker"])
+	assert.Equal(t, "connected", resp.Services["database"])
+}
+
+func TestReadyFailsWhileDraining(t *testing.T) {
+	s := NewServer(map[string]Check{"database": passing})
+	s.SetDraining()
+
+	code, resp := get(t, s, "/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "draining", resp.Services["shutdown"])
+
+	code, _ = get(t, s, "/health/live")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestMetrics(t *testing.T) {
+	code, _ := get(t, NewServer(nil), "/metrics")
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -0,0 +1,113 @@
+// Package broker defines how the ticket service publishes and consumes
+// messages, independent of the message broker. The rabbitmq package
+// implements it on AMQP; Memory implements it in-process for tests and local
+// development.
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+)
+
+var (
+	// ErrUnroutable is returned when no queue is bound for a mandatory message
+	ErrUnroutable = errors.New("message could not be routed to any queue")
+	// ErrClosed is returned by a broker that has been closed
+	ErrClosed = errors.New("broker is closed")
+)
+
+// Routing keys published by dws-event-service on the event exchange
+const (
+	RoutingKeyEventCancelled   = "event.cancelled"
+	RoutingKeyEventRescheduled = "event.rescheduled"
+)
+
+// Publisher publishes the ticket service's messages. Publishes return once
+// the broker has taken over the message.
+type Publisher interface {
+	PublishTicketPurchased(ctx context.Context, msg types.TicketMessage) error
+	PublishTicketConfirmed(ctx context.Context, msg types.TicketConfirmedMessage) error
+	PublishTicketPaymentFailed(ctx context.Context, msg types.TicketPaymentFailedMessage) error
+	PublishTicketNotification(ctx context.Context, msg types.TicketNotificationMessage) error
+	PublishTicketStatus(ctx context.Context, msg types.TicketStatusMessage) error
+	// PublishOutboxMessage publishes an already serialised message from the
+	// outbox with the outbox row ID as message ID
+	PublishOutboxMessage(ctx context.Context, exchange, routingKey, messageID, subject string, body []byte) error
+}
+
+// Subscriber delivers messages from the ticket service's queues. Deliveries
+// must be acked or nacked; failed ones are moved with Retry or DeadLetter.
+type Subscriber interface {
+	ConsumeTicketPurchased() (<-chan Delivery, error)
+	ConsumeEventLifecycle() (<-chan Delivery, error)
+	ConsumeTicketNotifications() (<-chan Delivery, error)
+	// ConsumeTicketStatus delivers every status change to every subscriber;
+	// its deliveries are acked automatically
+	ConsumeTicketStatus() (<-chan Delivery, error)
+	// Retry schedules another attempt of a delivery from queue after the
+	// backoff delay. Returns false without publishing once the retries are
+	// used up.
+	Retry(queue string, msg Delivery, cause error) (bool, error)
+	// DeadLetter moves a delivery from queue to its dead-letter queue
+	DeadLetter(queue string, msg Delivery, reason string) error
+	// CancelConsumers stops further deliveries. The delivery channels close
+	// once the deliveries already received have been read.
+	CancelConsumers() error
+}
+
+// Broker is a connection to the message broker
+type Broker interface {
+	Publisher
+	Subscriber
+	DeadLetters
+	// SetServiceName sets the source of published messages and the service
+	// label of broker metrics
+	SetServiceName(name string)
+	HealthCheck() error
+	Close() error
+}
+
+// Acknowledger settles deliveries with the broker they came from
+type Acknowledger interface {
+	Ack(tag uint64) error
+	Nack(tag uint64, requeue bool) error
+}
+
+// Delivery is a message received from a queue
+type Delivery struct {
+	MessageID     string
+	CorrelationID string
+	Type          string
+	AppID         string
+	ContentType   string
+	Timestamp     time.Time
+	Headers       map[string]interface{}
+	Body          []byte
+
+	Exchange    string
+	RoutingKey  string
+	Redelivered bool
+
+	DeliveryTag  uint64
+	Acknowledger Acknowledger
+}
+
+// Ack confirms the delivery was processed
+func (d Delivery) Ack() error {
+	if d.Acknowledger == nil {
+		return errors.New("delivery was acked automatically")
+	}
+	return d.Acknowledger.Ack(d.DeliveryTag)
+}
+
+// Nack rejects the delivery. With requeue it is delivered again, otherwise
+// it is dropped.
+func (d Delivery) Nack(requeue bool) error {
+	if d.Acknowledger == nil {
+		return errors.New("delivery was acked automatically")
+	}
+	return d.Acknowledger.Nack(d.DeliveryTag, requeue)
+}
@@ -0,0 +1,93 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+)
+
+// ErrUnknownQueue is returned for a queue without a dead-letter queue
+var ErrUnknownQueue = errors.New("queue has no dead-letter queue")
+
+// DeadLetters inspects and repairs the dead-letter queues of the consumed
+// queues. Queues are named by the work queue, e.g. ticket.purchased for
+// ticket.purchased.dlq.
+type DeadLetters interface {
+	// DeadLetters returns up to limit messages dead-lettered from queue,
+	// oldest first, without removing them. A limit of 0 returns all.
+	DeadLetters(queue string, limit int) ([]Delivery, error)
+	// ReplayDeadLetters moves the dead-lettered messages with the given IDs
+	// back onto queue with all their retries, and returns how many were moved
+	ReplayDeadLetters(queue string, messageIDs []string) (int, error)
+	// PurgeDeadLetters deletes the dead-lettered messages with the given IDs,
+	// or all of them without IDs, and returns how many were deleted
+	PurgeDeadLetters(queue string, messageIDs []string) (int, error)
+}
+
+// CheckDeadLetterQueue returns ErrUnknownQueue unless queue has a dead-letter
+// queue
+func CheckDeadLetterQueue(cfg *configs.RabbitMQConfig, queue string) error {
+	for _, q := range ConsumedQueues(cfg) {
+		if q == queue {
+			return nil
+		}
+	}
+	return ErrUnknownQueue
+}
+
+// ReplayHeaders returns the headers of a dead-lettered message replayed onto
+// its queue. The failure headers are dropped so it gets all retries again.
+func ReplayHeaders(msg Delivery) map[string]interface{} {
+	headers := copyHeaders(msg.Headers)
+	for _, h := range []string{
+		HeaderRetryCount,
+		HeaderLastError,
+		HeaderOriginalQueue,
+		HeaderDeadLetterReason,
+		HeaderDeadLetteredAt,
+	} {
+		delete(headers, h)
+	}
+	return headers
+}
+
+// MessageIDSet returns a lookup of message IDs
+func MessageIDSet(messageIDs []string) map[string]bool {
+	ids := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		ids[id] = true
+	}
+	return ids
+}
+
+// DescribeDeadLetter summarises a dead-lettered message and why it failed,
+// with its body if withBody is set
+func DescribeDeadLetter(msg Delivery, withBody bool) types.DeadLetterMessage {
+	described := types.DeadLetterMessage{
+		MessageID:     msg.MessageID,
+		Type:          msg.Type,
+		CorrelationID: msg.CorrelationID,
+		Source:        msg.AppID,
+		RetryCount:    RetryCount(msg),
+		PublishedAt:   msg.Timestamp,
+	}
+	described.Queue, _ = msg.Headers[HeaderOriginalQueue].(string)
+	described.Reason, _ = msg.Headers[HeaderDeadLetterReason].(string)
+	described.LastError, _ = msg.Headers[HeaderLastError].(string)
+	if at, ok := msg.Headers[HeaderDeadLetteredAt].(time.Time); ok {
+		described.DeadLetteredAt = &at
+	}
+
+	if withBody {
+		if json.Valid(msg.Body) {
+			described.Body = json.RawMessage(msg.Body)
+		} else {
+			// Kept readable as a JSON string
+			described.Body, _ = json.Marshal(string(msg.Body))
+		}
+	}
+	return described
+}
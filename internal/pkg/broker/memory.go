@@ -0,0 +1,573 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/ids"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Memory is an in-process broker with the same exchanges, queues, envelope,
+// retries and dead-lettering as the RabbitMQ setup. Messages never leave the
+// process, so it suits unit tests and running a single process without
+// RabbitMQ. Like RabbitMQ, a nacked delivery is requeued at the head of its
+// queue and marked redelivered, or dropped without requeue.
+type Memory struct {
+	config *configs.RabbitMQConfig
+
+	mu          sync.Mutex
+	serviceName string
+	queues      map[string]*memoryQueue
+	// bindings route an exchange's routing keys to queue names; fanout
+	// exchanges bind with an empty routing key
+	bindings  map[string]map[string][]string
+	fanout    map[string]bool
+	unacked   map[uint64]unackedDelivery
+	lastTag   uint64
+	consumers []*memoryConsumer
+	closed    bool
+}
+
+var _ Broker = (*Memory)(nil)
+
+type memoryQueue struct {
+	name      string
+	messages  []Delivery
+	consumers []*memoryConsumer
+	// autoDelete queues are removed with their last consumer
+	autoDelete bool
+}
+
+type memoryConsumer struct {
+	queue   *memoryQueue
+	autoAck bool
+	// wake is signalled when the queue gets a message
+	wake chan struct{}
+	stop chan struct{}
+}
+
+type unackedDelivery struct {
+	queue *memoryQueue
+	msg   Delivery
+}
+
+// NewMemory returns an in-process broker with the topology of cfg
+func NewMemory(cfg *configs.Config) *Memory {
+	rmqCfg := &cfg.RabbitMQ
+	m := &Memory{
+		config:      rmqCfg,
+		serviceName: "dws-ticket-service",
+		queues:      make(map[string]*memoryQueue),
+		bindings:    make(map[string]map[string][]string),
+		fanout:      map[string]bool{rmqCfg.StatusExchange: true},
+		unacked:     make(map[uint64]unackedDelivery),
+	}
+
+	for _, queue := range []string{
+		rmqCfg.Queue.Purchased,
+		rmqCfg.Queue.Confirmed,
+		rmqCfg.Queue.PaymentFailed,
+		rmqCfg.Queue.Notification,
+	} {
+		m.declare(queue, false)
+		m.bind(rmqCfg.Exchange, queue, queue)
+	}
+
+	m.declare(rmqCfg.Queue.EventLifecycle, false)
+	for _, routingKey := range []string{RoutingKeyEventCancelled, RoutingKeyEventRescheduled} {
+		m.bind(rmqCfg.EventExchange, routingKey, rmqCfg.Queue.EventLifecycle)
+	}
+
+	for _, queue := range ConsumedQueues(rmqCfg) {
+		m.declare(DeadLetterQueueName(queue), false)
+		m.bind(rmqCfg.DeadLetterExchange, queue, DeadLetterQueueName(queue))
+	}
+
+	return m
+}
+
+func (m *Memory) declare(name string, autoDelete bool) {
+	if _, ok := m.queues[name]; !ok {
+		m.queues[name] = &memoryQueue{name: name, autoDelete: autoDelete}
+	}
+}
+
+func (m *Memory) bind(exchange, routingKey, queue string) {
+	if m.bindings[exchange] == nil {
+		m.bindings[exchange] = make(map[string][]string)
+	}
+	m.bindings[exchange][routingKey] = append(m.bindings[exchange][routingKey], queue)
+}
+
+// route returns the queues a message is delivered to. The default exchange
+// routes to the queue named by the routing key.
+func (m *Memory) route(exchange, routingKey string) []*memoryQueue {
+	if exchange == "" {
+		if q, ok := m.queues[routingKey]; ok {
+			return []*memoryQueue{q}
+		}
+		return nil
+	}
+	if m.fanout[exchange] {
+		routingKey = ""
+	}
+
+	var queues []*memoryQueue
+	for _, name := range m.bindings[exchange][routingKey] {
+		if q, ok := m.queues[name]; ok {
+			queues = append(queues, q)
+		}
+	}
+	return queues
+}
+
+// SetServiceName sets the source of published messages
+func (m *Memory) SetServiceName(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.serviceName = name
+}
+
+func (m *Memory) PublishTicketPurchased(ctx context.Context, msg types.TicketMessage) error {
+	return m.publishJSON(ctx, m.config.Exchange, m.config.Queue.Purchased, true, msg.TicketID, msg)
+}
+
+func (m *Memory) PublishTicketConfirmed(ctx context.Context, msg types.TicketConfirmedMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return m.publishEvent(ctx, m.config.Exchange, m.config.Queue.Confirmed, true, envelope.Envelope{
+		ID:      ConfirmedMessageID(msg.TicketID),
+		Subject: msg.TicketID,
+	}, body)
+}
+
+func (m *Memory) PublishTicketPaymentFailed(ctx context.Context, msg types.TicketPaymentFailedMessage) error {
+	return m.publishJSON(ctx, m.config.Exchange, m.config.Queue.PaymentFailed, true, msg.TicketID, msg)
+}
+
+func (m *Memory) PublishTicketNotification(ctx context.Context, msg types.TicketNotificationMessage) error {
+	return m.publishJSON(ctx, m.config.Exchange, m.config.Queue.Notification, true, msg.TicketID, msg)
+}
+
+func (m *Memory) PublishTicketStatus(ctx context.Context, msg types.TicketStatusMessage) error {
+	return m.publishJSON(ctx, m.config.StatusExchange, "", false, msg.TicketID, msg)
+}
+
+func (m *Memory) PublishOutboxMessage(ctx context.Context, exchange, routingKey, messageID, subject string, body []byte) error {
+	return m.publishEvent(ctx, exchange, routingKey, true, envelope.Envelope{
+		ID:      messageID,
+		Subject: subject,
+	}, body)
+}
+
+func (m *Memory) publishJSON(ctx context.Context, exchange, routingKey string, mandatory bool, subject string, msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return m.publishEvent(ctx, exchange, routingKey, mandatory, envelope.Envelope{Subject: subject}, body)
+}
+
+func (m *Memory) publishEvent(ctx context.Context, exchange, routingKey string, mandatory bool, env envelope.Envelope, body []byte) error {
+	m.mu.Lock()
+	source := m.serviceName
+	m.mu.Unlock()
+
+	env = NewEnvelope(ctx, m.config, source, exchange, routingKey, env)
+	return m.Publish(exchange, routingKey, mandatory, Delivery{
+		MessageID:     env.ID,
+		CorrelationID: env.CorrelationID,
+		Type:          env.Type,
+		AppID:         env.Source,
+		ContentType:   "application/json",
+		Timestamp:     env.Time,
+		Headers:       env.Headers(),
+		Body:          body,
+	})
+}
+
+// Publish routes a message to the queues bound for it, like another service
+// publishing to the broker would. A mandatory message that no queue is bound
+// for fails with ErrUnroutable.
+func (m *Memory) Publish(exchange, routingKey string, mandatory bool, msg Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	queues := m.route(exchange, routingKey)
+	if len(queues) == 0 {
+		if mandatory {
+			return ErrUnroutable
+		}
+		return nil
+	}
+
+	if msg.MessageID == "" {
+		msg.MessageID = ids.NewUUID()
+	}
+	msg.Exchange = exchange
+	msg.RoutingKey = routingKey
+	msg.Redelivered = false
+	msg.DeliveryTag = 0
+	msg.Acknowledger = nil
+	for _, q := range queues {
+		queued := msg
+		queued.Headers = copyHeaders(msg.Headers)
+		q.messages = append(q.messages, queued)
+		q.wakeConsumers()
+	}
+	return nil
+}
+
+func (q *memoryQueue) wakeConsumers() {
+	for _, c := range q.consumers {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Messages returns the messages waiting in a queue, oldest first
+func (m *Memory) Messages(queue string) []Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[queue]
+	if !ok {
+		return nil
+	}
+	return append([]Delivery(nil), q.messages...)
+}
+
+func (m *Memory) ConsumeTicketPurchased() (<-chan Delivery, error) {
+	return m.Consume(m.config.Queue.Purchased)
+}
+
+func (m *Memory) ConsumeEventLifecycle() (<-chan Delivery, error) {
+	return m.Consume(m.config.Queue.EventLifecycle)
+}
+
+func (m *Memory) ConsumeTicketNotifications() (<-chan Delivery, error) {
+	return m.Consume(m.config.Queue.Notification)
+}
+
+// ConsumeTicketStatus delivers status changes through a private queue that
+// is removed when the consumer is cancelled
+func (m *Memory) ConsumeTicketStatus() (<-chan Delivery, error) {
+	name := "amq.gen-" + ids.NewUUID()
+
+	m.mu.Lock()
+	m.declare(name, true)
+	m.bind(m.config.StatusExchange, "", name)
+	m.mu.Unlock()
+
+	return m.consume(name, true)
+}
+
+// Consume delivers the messages of any declared queue, to be acked or nacked
+func (m *Memory) Consume(queue string) (<-chan Delivery, error) {
+	return m.consume(queue, false)
+}
+
+func (m *Memory) consume(queueName string, autoAck bool) (<-chan Delivery, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrClosed
+	}
+	q, ok := m.queues[queueName]
+	if !ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("queue %s is not declared", queueName)
+	}
+
+	c := &memoryConsumer{
+		queue:   q,
+		autoAck: autoAck,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	q.consumers = append(q.consumers, c)
+	m.consumers = append(m.consumers, c)
+	m.mu.Unlock()
+
+	out := make(chan Delivery)
+	go m.deliver(c, out)
+	return out, nil
+}
+
+// deliver hands the queue's messages to a consumer until it is cancelled
+func (m *Memory) deliver(c *memoryConsumer, out chan<- Delivery) {
+	defer close(out)
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+
+		msg, ok := m.next(c)
+		if !ok {
+			select {
+			case <-c.stop:
+				return
+			case <-c.wake:
+			}
+			continue
+		}
+
+		select {
+		case out <- msg:
+		case <-c.stop:
+			// Never handed out; put it back for the next consumer
+			if !c.autoAck {
+				if err := m.Nack(msg.DeliveryTag, true); err != nil {
+					log.WithError(err).Warn("Failed to requeue undelivered message")
+				}
+			}
+			return
+		}
+	}
+}
+
+// next takes the first message off the consumer's queue
+func (m *Memory) next(c *memoryConsumer) (Delivery, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q := c.queue
+	if len(q.messages) == 0 {
+		return Delivery{}, false
+	}
+	msg := q.messages[0]
+	q.messages = q.messages[1:]
+
+	if !c.autoAck {
+		m.lastTag++
+		msg.DeliveryTag = m.lastTag
+		msg.Acknowledger = m
+		m.unacked[msg.DeliveryTag] = unackedDelivery{queue: q, msg: msg}
+	}
+	return msg, true
+}
+
+// Ack settles an unacked delivery
+func (m *Memory) Ack(tag uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.unacked[tag]; !ok {
+		return fmt.Errorf("unknown delivery tag %d", tag)
+	}
+	delete(m.unacked, tag)
+	return nil
+}
+
+// Nack rejects an unacked delivery, putting it back at the head of its queue
+// with requeue
+func (m *Memory) Nack(tag uint64, requeue bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.unacked[tag]
+	if !ok {
+		return fmt.Errorf("unknown delivery tag %d", tag)
+	}
+	delete(m.unacked, tag)
+
+	if requeue {
+		msg := u.msg
+		msg.Redelivered = true
+		msg.DeliveryTag = 0
+		msg.Acknowledger = nil
+		u.queue.messages = append([]Delivery{msg}, u.queue.messages...)
+		u.queue.wakeConsumers()
+	}
+	return nil
+}
+
+// Retry publishes a copy of msg back onto queue once the attempt's backoff
+// delay has passed
+func (m *Memory) Retry(queue string, msg Delivery, cause error) (bool, error) {
+	attempt := RetryCount(msg) + 1
+	if attempt > m.config.MaxRetries {
+		return false, nil
+	}
+
+	retry := republished(msg, RetryHeaders(msg, attempt, cause))
+	delay := RetryDelay(m.config, attempt)
+	if delay <= 0 {
+		return true, m.Publish("", queue, true, retry)
+	}
+
+	time.AfterFunc(delay, func() {
+		if err := m.Publish("", queue, true, retry); err != nil {
+			log.WithError(err).WithField("queue", queue).Warn("Failed to publish retried message")
+		}
+	})
+	return true, nil
+}
+
+// DeadLetter moves a copy of msg to the queue's dead-letter queue
+func (m *Memory) DeadLetter(queue string, msg Delivery, reason string) error {
+	return m.Publish(m.config.DeadLetterExchange, queue, true, republished(msg, DeadLetterHeaders(msg, queue, reason)))
+}
+
+func republished(msg Delivery, headers map[string]interface{}) Delivery {
+	return Delivery{
+		MessageID:     msg.MessageID,
+		CorrelationID: msg.CorrelationID,
+		Type:          msg.Type,
+		AppID:         msg.AppID,
+		ContentType:   msg.ContentType,
+		Timestamp:     msg.Timestamp,
+		Headers:       headers,
+		Body:          msg.Body,
+	}
+}
+
+// CancelConsumers closes all delivery channels. Deliveries already handed
+// out can still be acked or nacked.
+func (m *Memory) CancelConsumers() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cancelConsumers()
+	return nil
+}
+
+func (m *Memory) cancelConsumers() {
+	for _, c := range m.consumers {
+		close(c.stop)
+
+		q := c.queue
+		for i, qc := range q.consumers {
+			if qc == c {
+				q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+				break
+			}
+		}
+		if q.autoDelete && len(q.consumers) == 0 {
+			m.deleteQueue(q.name)
+		}
+	}
+	m.consumers = nil
+}
+
+func (m *Memory) deleteQueue(name string) {
+	delete(m.queues, name)
+	for _, keys := range m.bindings {
+		for key, queues := range keys {
+			for i, q := range queues {
+				if q == name {
+					keys[key] = append(queues[:i], queues[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+}
+
+func (m *Memory) HealthCheck() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close ends all consumers and fails further publishes
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	m.cancelConsumers()
+	return nil
+}
+
+// DeadLetters returns messages waiting in queue's dead-letter queue
+func (m *Memory) DeadLetters(queue string, limit int) ([]Delivery, error) {
+	if err := CheckDeadLetterQueue(m.config, queue); err != nil {
+		return nil, err
+	}
+
+	msgs := m.Messages(DeadLetterQueueName(queue))
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	return msgs, nil
+}
+
+// ReplayDeadLetters publishes the selected dead-lettered messages back onto
+// queue through the default exchange
+func (m *Memory) ReplayDeadLetters(queue string, messageIDs []string) (int, error) {
+	if err := CheckDeadLetterQueue(m.config, queue); err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, msg := range m.takeDeadLetters(queue, MessageIDSet(messageIDs)) {
+		if err := m.Publish("", queue, true, republished(msg, ReplayHeaders(msg))); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// PurgeDeadLetters drops the selected dead-lettered messages, or all of them
+func (m *Memory) PurgeDeadLetters(queue string, messageIDs []string) (int, error) {
+	if err := CheckDeadLetterQueue(m.config, queue); err != nil {
+		return 0, err
+	}
+
+	var ids map[string]bool
+	if len(messageIDs) > 0 {
+		ids = MessageIDSet(messageIDs)
+	}
+	return len(m.takeDeadLetters(queue, ids)), nil
+}
+
+// takeDeadLetters removes the messages with the given IDs from queue's
+// dead-letter queue, or all of them with nil IDs
+func (m *Memory) takeDeadLetters(queue string, ids map[string]bool) []Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[DeadLetterQueueName(queue)]
+	if !ok {
+		return nil
+	}
+
+	var taken, kept []Delivery
+	for _, msg := range q.messages {
+		if ids == nil || ids[msg.MessageID] {
+			taken = append(taken, msg)
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+	q.messages = kept
+	return taken
+}
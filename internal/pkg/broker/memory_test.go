@@ -0,0 +1,241 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() *configs.Config {
+	cfg := &configs.Config{}
+	cfg.RabbitMQ = configs.RabbitMQConfig{
+		Exchange:           "ticket_exchange",
+		EventExchange:      "event_exchange",
+		StatusExchange:     "ticket_status",
+		DeadLetterExchange: "ticket_dlx",
+		MaxRetries:         2,
+	}
+	cfg.RabbitMQ.Queue = configs.RabbitMQQueueConfig{
+		Purchased:      "ticket.purchased",
+		Confirmed:      "ticket.confirmed",
+		PaymentFailed:  "ticket.payment_failed",
+		Notification:   "ticket.notification",
+		EventLifecycle: "ticket-service.event-lifecycle",
+	}
+	return cfg
+}
+
+func TestMemoryRoutesPurchaseAndConfirmation(t *testing.T) {
+	cfg := testConfig()
+	api := NewMemory(cfg)
+	defer api.Close()
+
+	purchased, err := api.ConsumeTicketPurchased()
+	require.NoError(t, err)
+	confirmed, err := api.Consume(cfg.RabbitMQ.Queue.Confirmed)
+	require.NoError(t, err)
+
+	require.NoError(t, api.PublishTicketPurchased(context.Background(), types.TicketMessage{TicketID: "t1", EventID: "e1", Quantity: 2}))
+
+	// The consumer confirms the ticket in the purchase's correlation
+	msg := receive(t, purchased)
+	env, err := MessageEnvelope(msg, types.MessageTypeTicketPurchased)
+	require.NoError(t, err)
+	assert.Equal(t, types.MessageTypeTicketPurchased, env.Type)
+	assert.Equal(t, "t1", env.Subject)
+
+	var ticket types.TicketMessage
+	require.NoError(t, json.Unmarshal(msg.Body, &ticket))
+	ctx := envelope.WithCorrelationID(context.Background(), env.CorrelationID)
+	require.NoError(t, api.PublishTicketConfirmed(ctx, types.TicketConfirmedMessage{TicketID: ticket.TicketID, PaymentID: "pay-1"}))
+	require.NoError(t, msg.Ack())
+
+	confirmation := receive(t, confirmed)
+	assert.Equal(t, ConfirmedMessageID("t1"), confirmation.MessageID)
+	assert.Equal(t, env.CorrelationID, confirmation.CorrelationID)
+	assert.Equal(t, types.MessageTypeTicketConfirmed, confirmation.Type)
+	require.NoError(t, confirmation.Ack())
+}
+
+func TestMemoryNackRequeues(t *testing.T) {
+	cfg := testConfig()
+	m := NewMemory(cfg)
+	defer m.Close()
+
+	msgs, err := m.ConsumeTicketPurchased()
+	require.NoError(t, err)
+	require.NoError(t, m.PublishTicketPurchased(context.Background(), types.TicketMessage{TicketID: "t1"}))
+
+	first := receive(t, msgs)
+	assert.False(t, first.Redelivered)
+	require.NoError(t, first.Nack(true))
+
+	again := receive(t, msgs)
+	assert.Equal(t, first.MessageID, again.MessageID)
+	assert.True(t, again.Redelivered)
+	require.NoError(t, again.Ack())
+	assert.Error(t, again.Ack(), "a delivery can only be settled once")
+
+	require.NoError(t, m.PublishTicketPurchased(context.Background(), types.TicketMessage{TicketID: "t2"}))
+	second := receive(t, msgs)
+	require.NoError(t, second.Nack(false))
+	assert.Empty(t, m.Messages(cfg.RabbitMQ.Queue.Purchased), "nack without requeue drops the message")
+}
+
+func TestMemoryRetryAndDeadLetter(t *testing.T) {
+	cfg := testConfig()
+	m := NewMemory(cfg)
+	defer m.Close()
+	queue := cfg.RabbitMQ.Queue.Purchased
+
+	require.NoError(t, m.PublishTicketPurchased(context.Background(), types.TicketMessage{TicketID: "t1"}))
+	msgs, err := m.ConsumeTicketPurchased()
+	require.NoError(t, err)
+
+	// Without a base delay retries are published right away
+	for attempt := 1; attempt <= cfg.RabbitMQ.MaxRetries; attempt++ {
+		msg := receive(t, msgs)
+		assert.Equal(t, attempt-1, RetryCount(msg))
+		retried, err := m.Retry(queue, msg, assert.AnError)
+		require.NoError(t, err)
+		assert.True(t, retried)
+		require.NoError(t, msg.Ack())
+	}
+
+	msg := receive(t, msgs)
+	retried, err := m.Retry(queue, msg, assert.AnError)
+	require.NoError(t, err)
+	assert.False(t, retried)
+
+	require.NoError(t, m.DeadLetter(queue, msg, "retries exhausted"))
+	require.NoError(t, msg.Ack())
+
+	dead := m.Messages(DeadLetterQueueName(queue))
+	require.Len(t, dead, 1)
+	assert.Equal(t, msg.MessageID, dead[0].MessageID)
+	assert.Equal(t, "retries exhausted", dead[0].Headers[HeaderDeadLetterReason])
+	assert.Equal(t, int32(2), dead[0].Headers[HeaderRetryCount])
+}
+
+func TestMemoryStatusIsFannedOut(t *testing.T) {
+	m := NewMemory(testConfig())
+	defer m.Close()
+
+	first, err := m.ConsumeTicketStatus()
+	require.NoError(t, err)
+	second, err := m.ConsumeTicketStatus()
+	require.NoError(t, err)
+
+	require.NoError(t, m.PublishTicketStatus(context.Background(), types.TicketStatusMessage{TicketID: "t1", Status: "confirmed"}))
+
+	for _, msgs := range []<-chan Delivery{first, second} {
+		msg := receive(t, msgs)
+		assert.Equal(t, types.MessageTypeTicketStatus, msg.Type)
+		assert.Error(t, msg.Ack(), "status deliveries are acked automatically")
+	}
+}
+
+func TestMemoryMandatoryMessagesMustBeRoutable(t *testing.T) {
+	m := NewMemory(testConfig())
+	defer m.Close()
+
+	err := m.PublishOutboxMessage(context.Background(), "ticket_exchange", "ticket.unknown", "msg-1", "t1", []byte(`{}`))
+	assert.ErrorIs(t, err, ErrUnroutable)
+
+	// Nobody listening for status updates is fine
+	assert.NoError(t, m.PublishTicketStatus(context.Background(), types.TicketStatusMessage{TicketID: "t1"}))
+}
+
+func TestMemoryCancelConsumers(t *testing.T) {
+	m := NewMemory(testConfig())
+	defer m.Close()
+
+	require.NoError(t, m.PublishTicketPurchased(context.Background(), types.TicketMessage{TicketID: "t1"}))
+	msgs, err := m.ConsumeTicketPurchased()
+	require.NoError(t, err)
+	inFlight := receive(t, msgs)
+
+	require.NoError(t, m.CancelConsumers())
+	select {
+	case _, ok := <-msgs:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("delivery channel was not closed")
+	}
+
+	// Deliveries already handed out can still be settled
+	assert.NoError(t, inFlight.Ack())
+}
+
+func TestMemoryClose(t *testing.T) {
+	m := NewMemory(testConfig())
+	require.NoError(t, m.HealthCheck())
+	require.NoError(t, m.Close())
+
+	assert.ErrorIs(t, m.HealthCheck(), ErrClosed)
+	assert.ErrorIs(t, m.PublishTicketPurchased(context.Background(), types.TicketMessage{TicketID: "t1"}), ErrClosed)
+	_, err := m.ConsumeTicketPurchased()
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func receive(t *testing.T, msgs <-chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no delivery received")
+		return Delivery{}
+	}
+}
+
+func TestMemoryDeadLetters(t *testing.T) {
+	cfg := testConfig()
+	m := NewMemory(cfg)
+	defer m.Close()
+	queue := cfg.RabbitMQ.Queue.Purchased
+
+	msgs, err := m.ConsumeTicketPurchased()
+	require.NoError(t, err)
+	var ids []string
+	for _, ticketID := range []string{"t1", "t2", "t3"} {
+		require.NoError(t, m.PublishTicketPurchased(context.Background(), types.TicketMessage{TicketID: ticketID}))
+		msg := receive(t, msgs)
+		msg.Headers[HeaderRetryCount] = int32(cfg.RabbitMQ.MaxRetries)
+		require.NoError(t, m.DeadLetter(queue, msg, "retries exhausted"))
+		require.NoError(t, msg.Ack())
+		ids = append(ids, msg.MessageID)
+	}
+
+	dead, err := m.DeadLetters(queue, 2)
+	require.NoError(t, err)
+	require.Len(t, dead, 2)
+	assert.Equal(t, ids[:2], []string{dead[0].MessageID, dead[1].MessageID})
+	_, err = m.DeadLetters("unknown", 0)
+	assert.ErrorIs(t, err, ErrUnknownQueue)
+
+	// Replayed messages get all their retries again
+	replayed, err := m.ReplayDeadLetters(queue, []string{ids[0], "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	msg := receive(t, msgs)
+	assert.Equal(t, ids[0], msg.MessageID)
+	assert.Equal(t, 0, RetryCount(msg))
+	assert.NotContains(t, msg.Headers, HeaderDeadLetterReason)
+	require.NoError(t, msg.Ack())
+
+	purged, err := m.PurgeDeadLetters(queue, []string{ids[1]})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	dead, err = m.DeadLetters(queue, 0)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, ids[2], dead[0].MessageID)
+}
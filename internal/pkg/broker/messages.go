@@ -0,0 +1,162 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/ids"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+)
+
+// Headers used to track failed deliveries
+const (
+	HeaderRetryCount       = "x-retry-count"
+	HeaderLastError        = "x-last-error"
+	HeaderOriginalQueue    = "x-original-queue"
+	HeaderDeadLetterReason = "x-dead-letter-reason"
+	HeaderDeadLetteredAt   = "x-dead-lettered-at"
+)
+
+// maxHeaderErrorLength keeps error texts in headers short
+const maxHeaderErrorLength = 512
+
+// ConsumedQueues are the queues this service consumes, each of which gets
+// retries and a dead-letter queue
+func ConsumedQueues(cfg *configs.RabbitMQConfig) []string {
+	return []string{
+		cfg.Queue.Purchased,
+		cfg.Queue.Notification,
+		cfg.Queue.EventLifecycle,
+	}
+}
+
+// DeadLetterQueueName is the queue holding messages of queue that failed for good
+func DeadLetterQueueName(queue string) string {
+	return queue + ".dlq"
+}
+
+// RetryDelay is the delay before the given retry attempt (1-based), doubling
+// from the base delay
+func RetryDelay(cfg *configs.RabbitMQConfig, attempt int) time.Duration {
+	return time.Duration(cfg.RetryBaseDelaySeconds) * time.Second << (attempt - 1)
+}
+
+// RetryCount returns how often a delivery has been retried
+func RetryCount(msg Delivery) int {
+	switch v := msg.Headers[HeaderRetryCount].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// RetryHeaders returns the headers of the copy of msg published for the
+// given retry attempt
+func RetryHeaders(msg Delivery, attempt int, cause error) map[string]interface{} {
+	headers := copyHeaders(msg.Headers)
+	headers[HeaderRetryCount] = int32(attempt)
+	headers[HeaderLastError] = truncate(cause.Error(), maxHeaderErrorLength)
+	return headers
+}
+
+// DeadLetterHeaders returns the headers of the copy of msg moved from queue
+// to its dead-letter queue
+func DeadLetterHeaders(msg Delivery, queue, reason string) map[string]interface{} {
+	headers := copyHeaders(msg.Headers)
+	headers[HeaderOriginalQueue] = queue
+	headers[HeaderDeadLetterReason] = truncate(reason, maxHeaderErrorLength)
+	headers[HeaderDeadLetteredAt] = time.Now().UTC()
+	return headers
+}
+
+func copyHeaders(headers map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(headers)+3)
+	for k, v := range headers {
+		out[k] = v
+	}
+	return out
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// ConfirmedMessageID is the message ID of a ticket's ticket.confirmed
+// message. It is the same for every publish of the confirmation, so
+// consumers can drop the duplicates caused by redelivered confirmations.
+func ConfirmedMessageID(ticketID string) string {
+	return ticketID + ":confirmed"
+}
+
+// MessageType returns the envelope type of messages sent to a destination
+func MessageType(cfg *configs.RabbitMQConfig, exchange, routingKey string) string {
+	switch {
+	case exchange == cfg.StatusExchange:
+		return types.MessageTypeTicketStatus
+	case routingKey == cfg.Queue.Purchased:
+		return types.MessageTypeTicketPurchased
+	case routingKey == cfg.Queue.Confirmed:
+		return types.MessageTypeTicketConfirmed
+	case routingKey == cfg.Queue.PaymentFailed:
+		return types.MessageTypeTicketPaymentFailed
+	case routingKey == cfg.Queue.Notification:
+		return types.MessageTypeTicketNotification
+	default:
+		return routingKey
+	}
+}
+
+// NewEnvelope completes the envelope of a message published by source to a
+// destination. The message type and schema version follow from the
+// destination. The correlation ID is taken from ctx; a message published
+// outside of a correlated flow starts its own.
+func NewEnvelope(ctx context.Context, cfg *configs.RabbitMQConfig, source, exchange, routingKey string, env envelope.Envelope) envelope.Envelope {
+	if env.ID == "" {
+		env.ID = ids.NewUUID()
+	}
+	env.Type = MessageType(cfg, exchange, routingKey)
+	env.Version = 1
+	if version, ok := types.MessageSchemaVersions[env.Type]; ok {
+		env.Version = version
+	}
+	env.Source = source
+	env.Time = time.Now()
+	env.CorrelationID = envelope.CorrelationID(ctx)
+	if env.CorrelationID == "" {
+		env.CorrelationID = env.ID
+	}
+	return env
+}
+
+// MessageEnvelope returns the envelope of a delivery. Deliveries without one,
+// published before it was introduced or by services that don't set it, get
+// an envelope of version 1 of legacyType built from their properties.
+func MessageEnvelope(msg Delivery, legacyType string) (envelope.Envelope, error) {
+	env, ok, err := envelope.FromHeaders(msg.Headers)
+	if err != nil || ok {
+		return env, err
+	}
+
+	env = envelope.Envelope{
+		ID:            msg.MessageID,
+		Source:        msg.AppID,
+		Type:          legacyType,
+		Version:       1,
+		CorrelationID: msg.CorrelationID,
+		Time:          msg.Timestamp,
+	}
+	if env.CorrelationID == "" {
+		env.CorrelationID = msg.MessageID
+	}
+	return env, nil
+}
@@ -0,0 +1,98 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cfg := &configs.RabbitMQConfig{RetryBaseDelaySeconds: 5}
+
+	assert.Equal(t, 5*time.Second, RetryDelay(cfg, 1))
+	assert.Equal(t, 10*time.Second, RetryDelay(cfg, 2))
+	assert.Equal(t, 80*time.Second, RetryDelay(cfg, 5))
+}
+
+func TestRetryCount(t *testing.T) {
+	assert.Equal(t, 0, RetryCount(Delivery{}))
+	assert.Equal(t, 3, RetryCount(Delivery{Headers: map[string]interface{}{HeaderRetryCount: int32(3)}}))
+	assert.Equal(t, 4, RetryCount(Delivery{Headers: map[string]interface{}{HeaderRetryCount: int64(4)}}))
+}
+
+func TestDeadLetterQueueName(t *testing.T) {
+	assert.Equal(t, "ticket.purchased.dlq", DeadLetterQueueName("ticket.purchased"))
+}
+
+func TestFailureHeadersKeepTheOriginal(t *testing.T) {
+	msg := Delivery{Headers: map[string]interface{}{envelope.HeaderID: "msg-1"}}
+
+	retry := RetryHeaders(msg, 2, assert.AnError)
+	assert.Equal(t, int32(2), retry[HeaderRetryCount])
+	assert.Equal(t, assert.AnError.Error(), retry[HeaderLastError])
+	assert.Equal(t, "msg-1", retry[envelope.HeaderID])
+
+	dead := DeadLetterHeaders(msg, "ticket.purchased", "bad json")
+	assert.Equal(t, "ticket.purchased", dead[HeaderOriginalQueue])
+	assert.Equal(t, "bad json", dead[HeaderDeadLetterReason])
+	assert.NotContains(t, msg.Headers, HeaderOriginalQueue)
+}
+
+func TestMessageType(t *testing.T) {
+	cfg := &configs.RabbitMQConfig{StatusExchange: "ticket_status"}
+	cfg.Queue.Purchased = "ticket.purchased"
+	cfg.Queue.Notification = "ticket.notification"
+
+	assert.Equal(t, types.MessageTypeTicketPurchased, MessageType(cfg, "ticket_exchange", "ticket.purchased"))
+	assert.Equal(t, types.MessageTypeTicketNotification, MessageType(cfg, "ticket_exchange", "ticket.notification"))
+	assert.Equal(t, types.MessageTypeTicketStatus, MessageType(cfg, "ticket_status", ""))
+	assert.Equal(t, "ticket.other", MessageType(cfg, "ticket_exchange", "ticket.other"))
+}
+
+func TestNewEnvelopeCorrelation(t *testing.T) {
+	cfg := &configs.RabbitMQConfig{}
+	cfg.Queue.Purchased = "ticket.purchased"
+
+	root := NewEnvelope(context.Background(), cfg, "dws-ticket-service", "ticket_exchange", "ticket.purchased", envelope.Envelope{})
+	assert.NotEmpty(t, root.ID)
+	assert.Equal(t, root.ID, root.CorrelationID)
+	assert.Equal(t, types.MessageTypeTicketPurchased, root.Type)
+	assert.Equal(t, 1, root.Version)
+
+	ctx := envelope.WithCorrelationID(context.Background(), root.CorrelationID)
+	follow := NewEnvelope(ctx, cfg, "dws-ticket-service-consumer", "ticket_exchange", "ticket.purchased", envelope.Envelope{ID: "msg-2"})
+	assert.Equal(t, "msg-2", follow.ID)
+	assert.Equal(t, root.CorrelationID, follow.CorrelationID)
+}
+
+func TestMessageEnvelope(t *testing.T) {
+	env := envelope.Envelope{
+		ID:            "msg-1",
+		Source:        "dws-ticket-service",
+		Type:          types.MessageTypeTicketPurchased,
+		Version:       2,
+		CorrelationID: "corr-1",
+		Time:          time.Now().UTC(),
+	}
+
+	decoded, err := MessageEnvelope(Delivery{Headers: env.Headers()}, types.MessageTypeTicketNotification)
+	require.NoError(t, err)
+	assert.Equal(t, env, decoded)
+}
+
+func TestMessageEnvelopeOfLegacyMessage(t *testing.T) {
+	sent := time.Now()
+	env, err := MessageEnvelope(Delivery{MessageID: "msg-1", Timestamp: sent}, types.MessageTypeTicketPurchased)
+	require.NoError(t, err)
+
+	assert.Equal(t, types.MessageTypeTicketPurchased, env.Type)
+	assert.Equal(t, 1, env.Version)
+	assert.Equal(t, "msg-1", env.CorrelationID)
+	assert.Equal(t, sent, env.Time)
+}
@@ -0,0 +1,223 @@
+// Package envelope describes broker messages with CloudEvents attributes.
+// Messages use the binary content mode: the body is the event data and the
+// attributes travel as message headers, so consumers that only read the body
+// keep working.
+package envelope
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpecVersion is the CloudEvents version of the envelope
+const SpecVersion = "1.0"
+
+// Header names of the envelope attributes, following the "cloudEvents:"
+// prefix of the CloudEvents AMQP binding
+const (
+	HeaderSpecVersion   = "cloudEvents:specversion"
+	HeaderID            = "cloudEvents:id"
+	HeaderSource        = "cloudEvents:source"
+	HeaderType          = "cloudEvents:type"
+	HeaderSubject       = "cloudEvents:subject"
+	HeaderTime          = "cloudEvents:time"
+	HeaderDataSchema    = "cloudEvents:dataschema"
+	HeaderCorrelationID = "cloudEvents:correlationid"
+)
+
+// dataSchemaPrefix starts the URN that names a type's schema version
+const dataSchemaPrefix = "urn:dws:schema:"
+
+var (
+	// ErrInvalidEnvelope is returned for headers that are not a valid envelope
+	ErrInvalidEnvelope = errors.New("invalid message envelope")
+	// ErrUnknownType is returned for a message type without a decoder
+	ErrUnknownType = errors.New("unknown message type")
+	// ErrUnsupportedVersion is returned for a schema version without a decoder
+	ErrUnsupportedVersion = errors.New("unsupported message schema version")
+)
+
+// Envelope holds the CloudEvents attributes of a message
+type Envelope struct {
+	ID      string
+	Source  string
+	Type    string
+	Subject string
+	// Version is the schema version of the message data
+	Version int
+	// CorrelationID is shared by all messages caused by the same request
+	CorrelationID string
+	Time          time.Time
+}
+
+// DataSchema returns the dataschema URI of a message type's schema version
+func DataSchema(messageType string, version int) string {
+	return fmt.Sprintf("%s%s:v%d", dataSchemaPrefix, messageType, version)
+}
+
+// ParseDataSchema returns the message type and schema version named by a
+// dataschema URI
+func ParseDataSchema(schema string) (string, int, error) {
+	rest, ok := strings.CutPrefix(schema, dataSchemaPrefix)
+	if !ok {
+		return "", 0, fmt.Errorf("%w: unknown dataschema %q", ErrInvalidEnvelope, schema)
+	}
+	i := strings.LastIndex(rest, ":v")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("%w: dataschema %q has no version", ErrInvalidEnvelope, schema)
+	}
+	version, err := strconv.Atoi(rest[i+2:])
+	if err != nil || version < 1 {
+		return "", 0, fmt.Errorf("%w: dataschema %q has no version", ErrInvalidEnvelope, schema)
+	}
+	return rest[:i], version, nil
+}
+
+// Headers returns the envelope as message headers
+func (e Envelope) Headers() map[string]interface{} {
+	headers := map[string]interface{}{
+		HeaderSpecVersion: SpecVersion,
+		HeaderID:          e.ID,
+		HeaderSource:      e.Source,
+		HeaderType:        e.Type,
+		HeaderTime:        e.Time.UTC().Format(time.RFC3339Nano),
+		HeaderDataSchema:  DataSchema(e.Type, e.Version),
+	}
+	if e.Subject != "" {
+		headers[HeaderSubject] = e.Subject
+	}
+	if e.CorrelationID != "" {
+		headers[HeaderCorrelationID] = e.CorrelationID
+	}
+	return headers
+}
+
+// FromHeaders reads the envelope from message headers. It returns false
+// without an error for messages that carry no envelope, such as those
+// published before it was introduced or by other services.
+func FromHeaders(headers map[string]interface{}) (Envelope, bool, error) {
+	specVersion, ok := headers[HeaderSpecVersion].(string)
+	if !ok {
+		return Envelope{}, false, nil
+	}
+	if specVersion != SpecVersion {
+		return Envelope{}, true, fmt.Errorf("%w: unsupported specversion %q", ErrInvalidEnvelope, specVersion)
+	}
+
+	env := Envelope{
+		ID:            stringHeader(headers, HeaderID),
+		Source:        stringHeader(headers, HeaderSource),
+		Type:          stringHeader(headers, HeaderType),
+		Subject:       stringHeader(headers, HeaderSubject),
+		CorrelationID: stringHeader(headers, HeaderCorrelationID),
+	}
+	if env.ID == "" || env.Source == "" || env.Type == "" {
+		return Envelope{}, true, fmt.Errorf("%w: id, source and type are required", ErrInvalidEnvelope)
+	}
+
+	if value := stringHeader(headers, HeaderTime); value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return Envelope{}, true, fmt.Errorf("%w: invalid time %q", ErrInvalidEnvelope, value)
+		}
+		env.Time = t
+	}
+
+	schemaType, version, err := ParseDataSchema(stringHeader(headers, HeaderDataSchema))
+	if err != nil {
+		return Envelope{}, true, err
+	}
+	if schemaType != env.Type {
+		return Envelope{}, true, fmt.Errorf("%w: dataschema is for %q, not %q", ErrInvalidEnvelope, schemaType, env.Type)
+	}
+	env.Version = version
+
+	return env, true, nil
+}
+
+func stringHeader(headers map[string]interface{}, name string) string {
+	value, _ := headers[name].(string)
+	return value
+}
+
+// DecodeFunc decodes the data of one schema version into the current message
+// struct of its type
+type DecodeFunc func(data []byte) (interface{}, error)
+
+// JSON decodes data that matches the current struct T
+func JSON[T any]() DecodeFunc {
+	return func(data []byte) (interface{}, error) {
+		var msg T
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+}
+
+// Upgrade decodes data of an older schema version into Old and converts it
+// to the current struct
+func Upgrade[Old, New any](convert func(Old) New) DecodeFunc {
+	return func(data []byte) (interface{}, error) {
+		var msg Old
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		return convert(msg), nil
+	}
+}
+
+// Decoder decodes message data by type and schema version, so consumers
+// keep accepting messages of older versions after a struct changes
+type Decoder struct {
+	decoders map[string]map[int]DecodeFunc
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{decoders: make(map[string]map[int]DecodeFunc)}
+}
+
+// Register sets the decoder of a message type's schema version
+func (d *Decoder) Register(messageType string, version int, decode DecodeFunc) {
+	if d.decoders[messageType] == nil {
+		d.decoders[messageType] = make(map[int]DecodeFunc)
+	}
+	d.decoders[messageType][version] = decode
+}
+
+// Decode decodes data described by env
+func (d *Decoder) Decode(env Envelope, data []byte) (interface{}, error) {
+	versions, ok := d.decoders[env.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, env.Type)
+	}
+	decode, ok := versions[env.Version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, env.Type, env.Version)
+	}
+
+	msg, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s v%d: %w", env.Type, env.Version, err)
+	}
+	return msg, nil
+}
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a context whose published messages carry the
+// correlation ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID set on ctx, if any
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
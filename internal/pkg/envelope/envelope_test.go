@@ -0,0 +1,120 @@
+package envelope
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type purchasedV1 struct {
+	TicketID string  `json:"ticket_id"`
+	Price    float64 `json:"price"`
+}
+
+type purchasedV2 struct {
+	TicketID   string `json:"ticket_id"`
+	PriceCents int    `json:"price_cents"`
+}
+
+func TestHeadersRoundTrip(t *testing.T) {
+	env := Envelope{
+		ID:            "msg-1",
+		Source:        "dws-ticket-service",
+		Type:          "dws.ticket.purchased",
+		Subject:       "ticket-1",
+		Version:       2,
+		CorrelationID: "corr-1",
+		Time:          time.Date(2026, 5, 1, 12, 30, 0, 123000000, time.UTC),
+	}
+
+	headers := env.Headers()
+	assert.Equal(t, "1.0", headers[HeaderSpecVersion])
+	assert.Equal(t, "urn:dws:schema:dws.ticket.purchased:v2", headers[HeaderDataSchema])
+
+	decoded, ok, err := FromHeaders(headers)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, env, decoded)
+}
+
+func TestFromHeadersWithoutEnvelope(t *testing.T) {
+	_, ok, err := FromHeaders(map[string]interface{}{"x-retry-count": int32(1)})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = FromHeaders(nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestFromHeadersRejectsInvalidEnvelopes(t *testing.T) {
+	valid := Envelope{ID: "msg-1", Source: "svc", Type: "dws.ticket.purchased", Version: 1, Time: time.Now()}
+
+	tests := map[string]func(map[string]interface{}){
+		"spec version": func(h map[string]interface{}) { h[HeaderSpecVersion] = "0.3" },
+		"missing id":   func(h map[string]interface{}) { delete(h, HeaderID) },
+		"bad time":     func(h map[string]interface{}) { h[HeaderTime] = "yesterday" },
+		"bad schema":   func(h map[string]interface{}) { h[HeaderDataSchema] = "https://example.com/schema.json" },
+		"schema type":  func(h map[string]interface{}) { h[HeaderDataSchema] = DataSchema("dws.ticket.status", 1) },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			headers := valid.Headers()
+			mutate(headers)
+
+			_, ok, err := FromHeaders(headers)
+			assert.True(t, ok)
+			assert.ErrorIs(t, err, ErrInvalidEnvelope)
+		})
+	}
+}
+
+func TestParseDataSchema(t *testing.T) {
+	messageType, version, err := ParseDataSchema("urn:dws:schema:dws.ticket.purchased:v12")
+	require.NoError(t, err)
+	assert.Equal(t, "dws.ticket.purchased", messageType)
+	assert.Equal(t, 12, version)
+
+	for _, schema := range []string{"urn:dws:schema:dws.ticket.purchased", "urn:dws:schema:dws.ticket.purchased:v0", "urn:dws:schema::v1"} {
+		_, _, err := ParseDataSchema(schema)
+		assert.ErrorIs(t, err, ErrInvalidEnvelope, schema)
+	}
+}
+
+func TestDecoderUpgradesOlderVersions(t *testing.T) {
+	decoder := NewDecoder()
+	decoder.Register("dws.ticket.purchased", 1, Upgrade(func(old purchasedV1) purchasedV2 {
+		return purchasedV2{TicketID: old.TicketID, PriceCents: int(old.Price * 100)}
+	}))
+	decoder.Register("dws.ticket.purchased", 2, JSON[purchasedV2]())
+
+	v1, err := decoder.Decode(Envelope{Type: "dws.ticket.purchased", Version: 1}, []byte(`{"ticket_id":"t1","price":12.5}`))
+	require.NoError(t, err)
+	assert.Equal(t, purchasedV2{TicketID: "t1", PriceCents: 1250}, v1)
+
+	v2, err := decoder.Decode(Envelope{Type: "dws.ticket.purchased", Version: 2}, []byte(`{"ticket_id":"t2","price_cents":990}`))
+	require.NoError(t, err)
+	assert.Equal(t, purchasedV2{TicketID: "t2", PriceCents: 990}, v2)
+}
+
+func TestDecoderErrors(t *testing.T) {
+	decoder := NewDecoder()
+	decoder.Register("dws.ticket.purchased", 1, JSON[purchasedV1]())
+
+	_, err := decoder.Decode(Envelope{Type: "dws.ticket.refunded", Version: 1}, []byte(`{}`))
+	assert.ErrorIs(t, err, ErrUnknownType)
+
+	_, err = decoder.Decode(Envelope{Type: "dws.ticket.purchased", Version: 3}, []byte(`{}`))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	_, err = decoder.Decode(Envelope{Type: "dws.ticket.purchased", Version: 1}, []byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestCorrelationID(t *testing.T) {
+	assert.Empty(t, CorrelationID(context.Background()))
+	assert.Equal(t, "corr-1", CorrelationID(WithCorrelationID(context.Background(), "corr-1")))
+}
@@ -0,0 +1,191 @@
+package eventclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+)
+
+// ErrEventNotFound is returned when dws-event-service has no event with the given ID
+var ErrEventNotFound = errors.New("event not found")
+
+// Sale statuses reported by dws-event-service
+const (
+	SaleStatusOnSale    = "on_sale"
+	SaleStatusScheduled = "scheduled"
+	SaleStatusSoldOut   = "sold_out"
+	SaleStatusClosed    = "closed"
+	SaleStatusCancelled = "cancelled"
+)
+
+// Event is the subset of a dws-event-service event the ticket service needs
+type Event struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	OrganizerID string    `json:"organizerId"`
+	Location    string    `json:"location,omitempty"`
+	StartDate   time.Time `json:"startDate"`
+	EndDate     time.Time `json:"endDate"`
+	SaleStatus  string    `json:"saleStatus"`
+}
+
+// HasEnded reports whether the event is over at the given time
+func (e *Event) HasEnded(now time.Time) bool {
+	end := e.EndDate
+	if end.IsZero() {
+		end = e.StartDate
+	}
+	return !end.After(now)
+}
+
+// OnSale reports whether tickets can currently be bought for the event
+func (e *Event) OnSale() bool {
+	return e.SaleStatus == SaleStatusOnSale
+}
+
+type cacheEntry struct {
+	event     *Event
+	err       error
+	expiresAt time.Time
+}
+
+// defaultCacheMaxEntries bounds the cache when no size is configured
+const defaultCacheMaxEntries = 10000
+
+// Client fetches events from dws-event-service, caching lookups (including
+// not-found results) for a short TTL so purchases don't hit the event service
+// on every request. Lifecycle changes are only picked up once the entry
+// expires, so the TTL should stay short.
+type Client struct {
+	baseURL         string
+	httpClient      *http.Client
+	cacheTTL        time.Duration
+	cacheMaxEntries int
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+func NewClient(cfg configs.EventServiceConfig) *Client {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	maxEntries := cfg.CacheMaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+
+	return &Client{
+		baseURL:         strings.TrimRight(cfg.URL, "/"),
+		httpClient:      &http.Client{Timeout: timeout},
+		cacheTTL:        time.Duration(cfg.CacheTTLSeconds) * time.Second,
+		cacheMaxEntries: maxEntries,
+		cache:           make(map[string]cacheEntry),
+	}
+}
+
+// GetEvent returns the event with the given ID or ErrEventNotFound
+func (c *Client) GetEvent(ctx context.Context, eventID string) (*Event, error) {
+	if event, err, ok := c.cached(eventID); ok {
+		return event, err
+	}
+
+	event, err := c.fetchEvent(ctx, eventID)
+	if err == nil || errors.Is(err, ErrEventNotFound) {
+		c.store(eventID, event, err)
+	}
+	return event, err
+}
+
+func (c *Client) fetchEvent(ctx context.Context, eventID string) (*Event, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/v1/events/"+url.PathEscape(eventID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build event request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("event service request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrEventNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("event service returned status %d", resp.StatusCode)
+	}
+
+	var event Event
+	if err := json.NewDecoder(resp.Body).Decode(&event); err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+
+	return &event, nil
+}
+
+func (c *Client) cached(eventID string) (*Event, error, bool) {
+	if c.cacheTTL <= 0 {
+		return nil, nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[eventID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(c.cache, eventID)
+		return nil, nil, false
+	}
+	return entry.event, entry.err, true
+}
+
+func (c *Client) store(eventID string, event *Event, err error) {
+	if c.cacheTTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.cache[eventID]; !ok && len(c.cache) >= c.cacheMaxEntries {
+		c.evict()
+	}
+	c.cache[eventID] = cacheEntry{
+		event:     event,
+		err:       err,
+		expiresAt: time.Now().Add(c.cacheTTL),
+	}
+}
+
+// evict makes room in a full cache, so lookups of random event IDs can't grow
+// it without bound. Expired entries go first, otherwise the oldest one.
+// Callers hold mu.
+func (c *Client) evict() {
+	now := time.Now()
+	oldestID := ""
+	var oldest time.Time
+	for id, entry := range c.cache {
+		if now.After(entry.expiresAt) {
+			delete(c.cache, id)
+			continue
+		}
+		if oldestID == "" || entry.expiresAt.Before(oldest) {
+			oldestID, oldest = id, entry.expiresAt
+		}
+	}
+	if len(c.cache) >= c.cacheMaxEntries {
+		delete(c.cache, oldestID)
+	}
+}
@@ -0,0 +1,78 @@
+package eventclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/eventclient"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/eventclient/eventclienttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetEvent(t *testing.T) {
+	srv := eventclienttest.NewServer(eventclient.Event{
+		ID:          "evt-001",
+		Name:        "Concert",
+		OrganizerID: "org-1",
+		StartDate:   time.Now().Add(24 * time.Hour),
+		SaleStatus:  eventclient.SaleStatusOnSale,
+	})
+	defer srv.Close()
+
+	client := eventclient.NewClient(configs.EventServiceConfig{URL: srv.URL, CacheTTLSeconds: 60})
+	ctx := context.Background()
+
+	event, err := client.GetEvent(ctx, "evt-001")
+	require.NoError(t, err)
+	assert.Equal(t, "org-1", event.OrganizerID)
+	assert.True(t, event.OnSale())
+	assert.False(t, event.HasEnded(time.Now()))
+
+	// Served from cache
+	_, err = client.GetEvent(ctx, "evt-001")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), srv.Requests())
+
+	_, err = client.GetEvent(ctx, "evt-missing")
+	assert.ErrorIs(t, err, eventclient.ErrEventNotFound)
+	_, err = client.GetEvent(ctx, "evt-missing")
+	assert.ErrorIs(t, err, eventclient.ErrEventNotFound)
+	assert.Equal(t, int64(2), srv.Requests())
+}
+
+func TestGetEventCacheIsBounded(t *testing.T) {
+	srv := eventclienttest.NewServer(eventclient.Event{ID: "evt-001", SaleStatus: eventclient.SaleStatusOnSale})
+	defer srv.Close()
+
+	client := eventclient.NewClient(configs.EventServiceConfig{URL: srv.URL, CacheTTLSeconds: 60, CacheMaxEntries: 2})
+	ctx := context.Background()
+
+	_, err := client.GetEvent(ctx, "evt-001")
+	require.NoError(t, err)
+	for _, id := range []string{"evt-random-1", "evt-random-2"} {
+		_, err = client.GetEvent(ctx, id)
+		assert.ErrorIs(t, err, eventclient.ErrEventNotFound)
+	}
+	assert.Equal(t, int64(3), srv.Requests())
+
+	// The oldest entry made room for the last lookup
+	_, err = client.GetEvent(ctx, "evt-random-2")
+	assert.ErrorIs(t, err, eventclient.ErrEventNotFound)
+	assert.Equal(t, int64(3), srv.Requests())
+	_, err = client.GetEvent(ctx, "evt-001")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), srv.Requests())
+}
+
+func TestGetEventUnavailable(t *testing.T) {
+	srv := eventclienttest.NewServer()
+	srv.Close()
+
+	client := eventclient.NewClient(configs.EventServiceConfig{URL: srv.URL, TimeoutSeconds: 1})
+	_, err := client.GetEvent(context.Background(), "evt-001")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, eventclient.ErrEventNotFound)
+}
@@ -0,0 +1,67 @@
+// Package eventclienttest provides a local stand-in for dws-event-service
+// for tests and local development.
+package eventclienttest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/eventclient"
+)
+
+// Server serves GET /api/v1/events/:id from an in-memory set of events
+type Server struct {
+	*httptest.Server
+
+	mu       sync.RWMutex
+	events   map[string]eventclient.Event
+	requests atomic.Int64
+}
+
+func NewServer(events ...eventclient.Event) *Server {
+	s := &Server{events: make(map[string]eventclient.Event)}
+	for _, e := range events {
+		s.events[e.ID] = e
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// SetEvent adds or replaces an event
+func (s *Server) SetEvent(e eventclient.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events[e.ID] = e
+}
+
+// Requests returns how many requests the server has handled
+func (s *Server) Requests() int64 {
+	return s.requests.Load()
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+
+	id, ok := strings.CutPrefix(r.URL.Path, "/api/v1/events/")
+	if r.Method != http.MethodGet || !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.RLock()
+	event, found := s.events[id]
+	s.mu.RUnlock()
+
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(event)
+}
@@ -0,0 +1,125 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ContentType is the MIME type of iCalendar data
+const ContentType = "text/calendar; charset=utf-8"
+
+// productID identifies this service as the calendar producer
+const productID = "-//DWS//dws-ticket-service//EN"
+
+// maxLineOctets is the line length limit of RFC 5545 section 3.1
+const maxLineOctets = 75
+
+// Event is a single VEVENT
+type Event struct {
+	UID          string
+	Sequence     int
+	Stamp        time.Time
+	Start        time.Time
+	End          *time.Time
+	Summary      string
+	Location     string
+	Description  string
+	URL          string
+	Cancelled    bool
+	LastModified time.Time
+}
+
+// Calendar is a VCALENDAR with its events
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Render serialises the calendar as RFC 5545 text with CRLF line endings and
+// folded long lines
+func Render(cal Calendar) []byte {
+	var buf bytes.Buffer
+	w := func(name, value string) {
+		writeLine(&buf, name+":"+value)
+	}
+
+	w("BEGIN", "VCALENDAR")
+	w("VERSION", "2.0")
+	w("PRODID", productID)
+	w("CALSCALE", "GREGORIAN")
+	w("METHOD", "PUBLISH")
+	if cal.Name != "" {
+		w("X-WR-CALNAME", escapeText(cal.Name))
+	}
+
+	for _, ev := range cal.Events {
+		w("BEGIN", "VEVENT")
+		w("UID", escapeText(ev.UID))
+		w("SEQUENCE", fmt.Sprint(ev.Sequence))
+		w("DTSTAMP", formatTime(ev.Stamp))
+		w("DTSTART", formatTime(ev.Start))
+		if ev.End != nil && ev.End.After(ev.Start) {
+			w("DTEND", formatTime(*ev.End))
+		}
+		w("SUMMARY", escapeText(ev.Summary))
+		if ev.Location != "" {
+			w("LOCATION", escapeText(ev.Location))
+		}
+		if ev.Description != "" {
+			w("DESCRIPTION", escapeText(ev.Description))
+		}
+		if ev.URL != "" {
+			w("URL", ev.URL)
+		}
+		if !ev.LastModified.IsZero() {
+			w("LAST-MODIFIED", formatTime(ev.LastModified))
+		}
+		if ev.Cancelled {
+			w("STATUS", "CANCELLED")
+		} else {
+			w("STATUS", "CONFIRMED")
+		}
+		w("END", "VEVENT")
+	}
+
+	w("END", "VCALENDAR")
+	return buf.Bytes()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// escapeText escapes a TEXT value (RFC 5545 section 3.3.11)
+func escapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	).Replace(s)
+}
+
+// writeLine writes a content line, folding it into chunks of at most 75
+// octets without splitting UTF-8 sequences. Continuation lines start with a
+// space, which counts towards their length.
+func writeLine(buf *bytes.Buffer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		// Back up to the start of a UTF-8 sequence
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+		limit = maxLineOctets - 1
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
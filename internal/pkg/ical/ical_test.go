@@ -0,0 +1,55 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	start := time.Date(2025, 11, 20, 19, 0, 0, 0, time.FixedZone("CET", 3600))
+	end := start.Add(3 * time.Hour)
+
+	out := string(Render(Calendar{
+		Name: "My tickets",
+		Events: []Event{{
+			UID:         "ticket-1@dws-ticket-service",
+			Sequence:    2,
+			Stamp:       time.Date(2025, 11, 1, 12, 0, 0, 0, time.UTC),
+			Start:       start,
+			End:         &end,
+			Summary:     "Tech Conference, Day 1; Keynote",
+			Location:    "Luleå",
+			Description: "2 tickets\nTicket ID: ticket-1",
+		}},
+	}))
+
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.True(t, strings.HasSuffix(out, "END:VCALENDAR\r\n"))
+	assert.Contains(t, out, "DTSTART:20251120T180000Z\r\n")
+	assert.Contains(t, out, "DTEND:20251120T210000Z\r\n")
+	assert.Contains(t, out, "SEQUENCE:2\r\n")
+	assert.Contains(t, out, `SUMMARY:Tech Conference\, Day 1\; Keynote`)
+	assert.Contains(t, out, `DESCRIPTION:2 tickets\nTicket ID: ticket-1`)
+	assert.Contains(t, out, "STATUS:CONFIRMED\r\n")
+}
+
+func TestWriteLineFolds(t *testing.T) {
+	summary := strings.Repeat("å", 100)
+	out := string(Render(Calendar{Events: []Event{{Summary: summary}}}))
+
+	var unfolded strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), maxLineOctets)
+		assert.True(t, utf8.ValidString(line))
+		if strings.HasPrefix(line, " ") {
+			unfolded.WriteString(line[1:])
+			continue
+		}
+		unfolded.WriteString("\n" + line)
+	}
+	assert.Contains(t, unfolded.String(), "SUMMARY:"+summary)
+}
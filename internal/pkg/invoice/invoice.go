@@ -0,0 +1,130 @@
+package invoice
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Party holds seller or buyer details printed on an invoice
+type Party struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	VATID   string `json:"vat_id,omitempty"`
+	Email   string `json:"email,omitempty"`
+}
+
+// LineItem is a single invoice line; prices are gross (tax included) in cents
+type LineItem struct {
+	Description    string `json:"description"`
+	Quantity       int    `json:"quantity"`
+	UnitPriceCents int64  `json:"unit_price_cents"`
+}
+
+// Invoice is the data needed to render a receipt/invoice
+type Invoice struct {
+	Number    string     `json:"number,omitempty"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	Currency  string     `json:"currency"`
+	TaxRate   float64    `json:"tax_rate"`
+	Reference string     `json:"reference"`
+	Seller    Party      `json:"seller"`
+	Buyer     Party      `json:"buyer"`
+	Lines     []LineItem `json:"lines"`
+}
+
+// Totals returns net, tax and gross amounts in cents. Line prices include
+// tax, so the tax portion is extracted from the gross sum.
+func (inv Invoice) Totals() (net, tax, gross int64) {
+	for _, line := range inv.Lines {
+		gross += int64(line.Quantity) * line.UnitPriceCents
+	}
+	net = int64(math.Round(float64(gross) / (1 + inv.TaxRate)))
+	return net, gross - net, gross
+}
+
+// ToCents converts a decimal amount to cents
+func ToCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// FormatNumber builds the printed invoice number from a prefix and sequence
+func FormatNumber(prefix string, sequence int) string {
+	return fmt.Sprintf("%s-%06d", prefix, sequence)
+}
+
+// Render produces the invoice as a PDF document
+func Render(inv Invoice) []byte {
+	doc := &pdfDocument{}
+	left := 50.0
+	y := float64(pageHeight) - 60
+
+	doc.text(left, y, 20, true, "Receipt / Invoice")
+	doc.text(350, y, 10, false, "Invoice no: "+inv.Number)
+	doc.text(350, y-14, 10, false, "Date: "+inv.IssuedAt.Format("2006-01-02"))
+	doc.text(350, y-28, 10, false, "Reference: "+inv.Reference)
+	y -= 70
+
+	writeParty := func(x, y float64, title string, p Party) {
+		doc.text(x, y, 11, true, title)
+		lines := []string{p.Name}
+		lines = append(lines, strings.Split(p.Address, "\n")...)
+		if p.VATID != "" {
+			lines = append(lines, "VAT ID: "+p.VATID)
+		}
+		if p.Email != "" {
+			lines = append(lines, p.Email)
+		}
+		for _, l := range lines {
+			if l == "" {
+				continue
+			}
+			y -= 14
+			doc.text(x, y, 10, false, l)
+		}
+	}
+	writeParty(left, y, "Seller", inv.Seller)
+	writeParty(350, y, "Buyer", inv.Buyer)
+	y -= 110
+
+	doc.text(left, y, 10, true, "Description")
+	doc.text(330, y, 10, true, "Qty")
+	doc.text(380, y, 10, true, "Unit price")
+	doc.text(470, y, 10, true, "Amount")
+	doc.line(left, y-6, 545, y-6)
+	y -= 22
+
+	for _, line := range inv.Lines {
+		doc.text(left, y, 10, false, line.Description)
+		doc.text(330, y, 10, false, fmt.Sprintf("%d", line.Quantity))
+		doc.text(380, y, 10, false, formatMoney(line.UnitPriceCents, inv.Currency))
+		doc.text(470, y, 10, false, formatMoney(int64(line.Quantity)*line.UnitPriceCents, inv.Currency))
+		y -= 16
+	}
+
+	net, tax, gross := inv.Totals()
+	doc.line(330, y+4, 545, y+4)
+	y -= 12
+	doc.text(330, y, 10, false, "Net amount")
+	doc.text(470, y, 10, false, formatMoney(net, inv.Currency))
+	y -= 16
+	doc.text(330, y, 10, false, fmt.Sprintf("VAT %.2f%%", inv.TaxRate*100))
+	doc.text(470, y, 10, false, formatMoney(tax, inv.Currency))
+	y -= 16
+	doc.text(330, y, 11, true, "Total")
+	doc.text(470, y, 11, true, formatMoney(gross, inv.Currency))
+
+	doc.text(left, 60, 8, false, "Paid in full. Thank you for your purchase.")
+
+	return doc.bytes()
+}
+
+func formatMoney(cents int64, currency string) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, cents/100, cents%100, currency)
+}
@@ -0,0 +1,46 @@
+package invoice
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTotals(t *testing.T) {
+	inv := Invoice{
+		TaxRate: 0.06,
+		Lines: []LineItem{
+			{Description: "Ticket", Quantity: 2, UnitPriceCents: 59900},
+		},
+	}
+
+	net, tax, gross := inv.Totals()
+	assert.Equal(t, int64(119800), gross)
+	assert.Equal(t, int64(113019), net)
+	assert.Equal(t, gross, net+tax)
+}
+
+func TestFormatNumber(t *testing.T) {
+	assert.Equal(t, "INV-000042", FormatNumber("INV", 42))
+}
+
+func TestRender(t *testing.T) {
+	pdf := Render(Invoice{
+		Number:    "INV-000001",
+		IssuedAt:  time.Date(2026, 1, 7, 20, 0, 0, 0, time.UTC),
+		Currency:  "SEK",
+		TaxRate:   0.06,
+		Reference: "ticket-123",
+		Seller:    Party{Name: "DWS Events (Org)", Address: "Storgatan 1\n971 87 Luleå"},
+		Buyer:     Party{Name: "Jane Doe", Email: "jane@example.com"},
+		Lines:     []LineItem{{Description: "Ticket for event evt-001", Quantity: 1, UnitPriceCents: 29900}},
+	})
+
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(pdf, []byte("%%EOF\n")))
+	assert.Contains(t, string(pdf), "INV-000001")
+	assert.Contains(t, string(pdf), `DWS Events \(Org\)`)
+	assert.Contains(t, string(pdf), `Lule\345`)
+}
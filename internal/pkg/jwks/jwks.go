@@ -0,0 +1,324 @@
+// Package jwks caches the signing keys an OpenID provider publishes as a JSON
+// Web Key Set. Keys are kept for as long as the provider's Cache-Control
+// allows and refreshed in the background when they expire. A token signed
+// with an unknown key ID triggers at most one fetch per refresh interval, and
+// the unknown key ID is remembered for a while, so bogus tokens can't be used
+// to flood the provider.
+package jwks
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnknownKey is returned for a key ID that isn't in the key set
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// fetchTimeout bounds a fetch of the key set
+const fetchTimeout = 10 * time.Second
+
+// maxUnknownKeys bounds the negative cache; unknown key IDs beyond it are
+// still throttled by the refresh interval
+const maxUnknownKeys = 1024
+
+// Options configures a Cache
+type Options struct {
+	// URL of the JWKS document
+	URL        string
+	HTTPClient *http.Client
+	// DefaultTTL is how long keys are kept when the response has no max-age
+	DefaultTTL time.Duration
+	// MinTTL and MaxTTL bound the max-age of the response
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// MinRefreshInterval is the least time between two fetches triggered by
+	// unknown key IDs, and between retries of a failed background refresh
+	MinRefreshInterval time.Duration
+	// UnknownKeyTTL is how long an unknown key ID is rejected without a fetch
+	UnknownKeyTTL time.Duration
+}
+
+// Cache holds the RSA signing keys of a key set by key ID. It is safe for
+// concurrent use.
+type Cache struct {
+	opts Options
+
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+	fetchedAt time.Time
+	// fetchErr is the error of the last fetch; key IDs aren't taken to be
+	// unknown while the key set can't be fetched
+	fetchErr error
+	// unknown holds key IDs missing from the last fetch, until when they are
+	// rejected without fetching again
+	unknown map[string]time.Time
+	// inflight is the running fetch; concurrent callers wait for it instead
+	// of fetching again
+	inflight *fetch
+
+	now func() time.Time
+}
+
+type fetch struct {
+	done chan struct{}
+	err  error
+}
+
+// New returns an empty cache. Keys are fetched on first use or by Run.
+func New(opts Options) *Cache {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: fetchTimeout}
+	}
+	return &Cache{
+		opts:    opts,
+		keys:    make(map[string]*rsa.PublicKey),
+		unknown: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Key returns the public key with the given key ID. Unknown key IDs fetch the
+// key set again, unless it was fetched within the refresh interval or the key
+// ID was recently found to be unknown.
+func (c *Cache) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	rejectedUntil, rejected := c.unknown[kid]
+	throttled := c.now().Sub(c.fetchedAt) < c.opts.MinRefreshInterval
+	c.mu.RUnlock()
+
+	if ok {
+		// Expired keys are still served while the refresh keeps failing
+		return key, nil
+	}
+	if rejected && c.now().Before(rejectedUntil) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+
+	if !throttled {
+		if err := c.Refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if c.fetchErr != nil {
+		return nil, c.fetchErr
+	}
+	c.rememberUnknown(kid)
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+}
+
+// rememberUnknown adds a key ID to the negative cache. Callers hold mu.
+func (c *Cache) rememberUnknown(kid string) {
+	now := c.now()
+	if len(c.unknown) >= maxUnknownKeys {
+		for id, until := range c.unknown {
+			if !now.Before(until) {
+				delete(c.unknown, id)
+			}
+		}
+		if len(c.unknown) >= maxUnknownKeys {
+			return
+		}
+	}
+	c.unknown[kid] = now.Add(c.opts.UnknownKeyTTL)
+}
+
+// Refresh fetches the key set. Concurrent calls share one fetch.
+func (c *Cache) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	f := c.inflight
+	if f == nil {
+		f = &fetch{done: make(chan struct{})}
+		c.inflight = f
+		go c.fetch(f)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetch runs a fetch detached from the caller, so a cancelled request doesn't
+// fail the other callers waiting for it
+func (c *Cache) fetch(f *fetch) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	keys, ttl, err := c.download(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	c.mu.Lock()
+	now := c.now()
+	c.fetchedAt = now
+	c.fetchErr = err
+	if err == nil {
+		c.keys = keys
+		c.expiresAt = now.Add(ttl)
+		for kid := range keys {
+			delete(c.unknown, kid)
+		}
+	}
+	c.inflight = nil
+	c.mu.Unlock()
+
+	f.err = err
+	if err == nil {
+		log.WithFields(log.Fields{
+			"count": len(keys),
+			"ttl":   ttl,
+		}).Info("Loaded signing keys")
+	}
+	close(f.done)
+}
+
+// keySet is the JSON Web Key Set document
+type keySet struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func (c *Cache) download(ctx context.Context) (map[string]*rsa.PublicKey, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.opts.URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set keySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode key set: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		publicKey, err := parseRSAPublicKey(key.N, key.E)
+		if err != nil {
+			log.WithError(err).WithField("kid", key.Kid).Warn("Failed to parse public key")
+			continue
+		}
+		keys[key.Kid] = publicKey
+	}
+
+	return keys, c.ttl(resp.Header.Get("Cache-Control")), nil
+}
+
+// ttl returns how long a response may be cached according to its
+// Cache-Control header, within the configured bounds
+func (c *Cache) ttl(cacheControl string) time.Duration {
+	ttl := c.opts.DefaultTTL
+	noCache := false
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			noCache = true
+		case strings.HasPrefix(directive, "max-age="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && seconds >= 0 {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if noCache {
+		ttl = 0
+	}
+
+	if ttl < c.opts.MinTTL {
+		ttl = c.opts.MinTTL
+	}
+	if c.opts.MaxTTL > 0 && ttl > c.opts.MaxTTL {
+		ttl = c.opts.MaxTTL
+	}
+	return ttl
+}
+
+// Run refreshes the key set before it expires until ctx is cancelled. A
+// failed refresh is retried after the refresh interval; the previous keys
+// stay in use meanwhile.
+func (c *Cache) Run(ctx context.Context) {
+	for {
+		var wait time.Duration
+		if err := c.Refresh(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.WithError(err).Warn("Failed to refresh signing keys")
+			wait = c.opts.MinRefreshInterval
+		} else {
+			c.mu.RLock()
+			wait = c.expiresAt.Sub(c.now())
+			c.mu.RUnlock()
+		}
+		if wait < c.opts.MinRefreshInterval {
+			wait = c.opts.MinRefreshInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func parseRSAPublicKey(nStr, eStr string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(nStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode n: %w", err)
+	}
+
+	eBytes, err := base64.RawURLEncoding.DecodeString(eStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode e: %w", err)
+	}
+
+	n := new(big.Int).SetBytes(nBytes)
+	e := new(big.Int).SetBytes(eBytes)
+
+	return &rsa.PublicKey{
+		N: n,
+		E: int(e.Int64()),
+	}, nil
+}
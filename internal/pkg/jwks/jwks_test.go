@@ -0,0 +1,195 @@
+package jwks
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// provider serves a key set and counts the fetches
+type provider struct {
+	mu           sync.Mutex
+	keys         map[string]*rsa.PublicKey
+	status       int
+	cacheControl string
+	fetches      atomic.Int32
+}
+
+func (p *provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.fetches.Add(1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.status != 0 {
+		w.WriteHeader(p.status)
+		return
+	}
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range p.keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kid": kid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	if p.cacheControl != "" {
+		w.Header().Set("Cache-Control", p.cacheControl)
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+func (p *provider) set(kid string, key *rsa.PublicKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[kid] = key
+}
+
+func newKey(t *testing.T) *rsa.PublicKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	return &key.PublicKey
+}
+
+// setup returns a cache over a provider with key "k1" and a clock to move
+func setup(t *testing.T) (*Cache, *provider, *atomic.Int64) {
+	t.Helper()
+	p := &provider{keys: map[string]*rsa.PublicKey{"k1": newKey(t)}}
+	server := httptest.NewServer(p)
+	t.Cleanup(server.Close)
+
+	var clock atomic.Int64
+	clock.Store(time.Now().UnixNano())
+	c := New(Options{
+		URL:                server.URL,
+		DefaultTTL:         time.Hour,
+		MinRefreshInterval: 10 * time.Second,
+		UnknownKeyTTL:      time.Minute,
+	})
+	c.now = func() time.Time { return time.Unix(0, clock.Load()) }
+	return c, p, &clock
+}
+
+func TestKeyFetchesOnce(t *testing.T) {
+	c, p, _ := setup(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := c.Key(context.Background(), "k1")
+			assert.NoError(t, err)
+			assert.Equal(t, p.keys["k1"].N, key.N)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), p.fetches.Load(), "concurrent misses share one fetch")
+}
+
+func TestUnknownKeysAreThrottled(t *testing.T) {
+	c, p, clock := setup(t)
+	ctx := context.Background()
+
+	_, err := c.Key(ctx, "k1")
+	require.NoError(t, err)
+
+	// Right after a fetch, unknown key IDs don't fetch again
+	for i := 0; i < 10; i++ {
+		_, err = c.Key(ctx, "bogus")
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	}
+	assert.Equal(t, int32(1), p.fetches.Load())
+
+	// Nor while the key ID is remembered as unknown
+	clock.Add(int64(30 * time.Second))
+	_, err = c.Key(ctx, "bogus")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, int32(1), p.fetches.Load())
+
+	// A rotated-in key is found once the refresh interval has passed
+	p.set("k2", newKey(t))
+	_, err = c.Key(ctx, "k2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), p.fetches.Load())
+}
+
+func TestFailedFetchDoesNotRememberUnknownKeys(t *testing.T) {
+	c, p, clock := setup(t)
+	ctx := context.Background()
+
+	p.status = http.StatusServiceUnavailable
+	_, err := c.Key(ctx, "k1")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnknownKey)
+
+	// Throttled, but not rejected as unknown
+	_, err = c.Key(ctx, "k1")
+	assert.NotErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, int32(1), p.fetches.Load())
+
+	p.mu.Lock()
+	p.status = 0
+	p.mu.Unlock()
+	clock.Add(int64(10 * time.Second))
+	_, err = c.Key(ctx, "k1")
+	assert.NoError(t, err)
+}
+
+func TestTTL(t *testing.T) {
+	c := New(Options{DefaultTTL: 15 * time.Minute, MinTTL: time.Minute, MaxTTL: 24 * time.Hour})
+
+	for header, want := range map[string]time.Duration{
+		"":                          15 * time.Minute,
+		"public, max-age=3600":      time.Hour,
+		"max-age=5":                 time.Minute,
+		"max-age=604800":            24 * time.Hour,
+		"no-cache":                  time.Minute,
+		"max-age=3600, no-store":    time.Minute,
+		"max-age=invalid":           15 * time.Minute,
+		"Public, Max-Age=120":       2 * time.Minute,
+		"must-revalidate, no-cache": time.Minute,
+	} {
+		assert.Equal(t, want, c.ttl(header), header)
+	}
+}
+
+func TestRunRefreshesWhenExpired(t *testing.T) {
+	c, p, _ := setup(t)
+	c.opts.MinRefreshInterval = 10 * time.Millisecond
+	c.now = time.Now
+	p.cacheControl = "max-age=0"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+
+	assert.Eventually(t, func() bool { return p.fetches.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	key, err := c.Key(context.Background(), "k1")
+	require.NoError(t, err)
+	assert.NotNil(t, key)
+}
@@ -0,0 +1,96 @@
+package live
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// subscriberBuffer is how many updates a slow subscriber may lag behind
+// before further updates to it are dropped
+const subscriberBuffer = 16
+
+// Hub fans ticket status updates out to in-process subscribers, e.g. open
+// SSE streams and sales dashboards. Each API replica runs its own hub fed from the status exchange.
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[string]map[chan types.TicketStatusMessage]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[string]map[chan types.TicketStatusMessage]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving status updates for a ticket and a
+// function that ends the subscription and closes the channel
+func (h *Hub) Subscribe(ticketID string) (<-chan types.TicketStatusMessage, func()) {
+	return h.subscribe("ticket:" + ticketID)
+}
+
+// SubscribeEvent is like Subscribe, for all tickets of an event
+func (h *Hub) SubscribeEvent(eventID string) (<-chan types.TicketStatusMessage, func()) {
+	return h.subscribe("event:" + eventID)
+}
+
+func (h *Hub) subscribe(topic string) (<-chan types.TicketStatusMessage, func()) {
+	ch := make(chan types.TicketStatusMessage, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.subs[topic] == nil {
+		h.subs[topic] = make(map[chan types.TicketStatusMessage]struct{})
+	}
+	h.subs[topic][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs[topic], ch)
+			if len(h.subs[topic]) == 0 {
+				delete(h.subs, topic)
+			}
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers an update to the ticket's and the event's subscribers
+// without blocking
+func (h *Hub) Publish(msg types.TicketStatusMessage) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, topic := range []string{"ticket:" + msg.TicketID, "event:" + msg.EventID} {
+		for ch := range h.subs[topic] {
+			select {
+			case ch <- msg:
+			default:
+				log.WithFields(log.Fields{
+					"ticket_id": msg.TicketID,
+					"topic":     topic,
+				}).Warn("Status subscriber is lagging, dropping update")
+			}
+		}
+	}
+}
+
+// Consume publishes status messages from the broker until the delivery
+// channel closes
+func (h *Hub) Consume(msgs <-chan broker.Delivery) {
+	for msg := range msgs {
+		var statusMsg types.TicketStatusMessage
+		if err := json.Unmarshal(msg.Body, &statusMsg); err != nil {
+			log.WithError(err).Warn("Failed to unmarshal ticket status message")
+			continue
+		}
+		h.Publish(statusMsg)
+	}
+	log.Warn("Ticket status feed closed")
+}
@@ -0,0 +1,77 @@
+package live
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubPublish(t *testing.T) {
+	hub := NewHub()
+	updates, unsubscribe := hub.Subscribe("ticket-1")
+	other, unsubscribeOther := hub.Subscribe("ticket-2")
+	defer unsubscribeOther()
+
+	hub.Publish(types.TicketStatusMessage{TicketID: "ticket-1", Status: "confirmed"})
+
+	msg := <-updates
+	assert.Equal(t, "confirmed", msg.Status)
+	assert.Empty(t, other)
+
+	unsubscribe()
+	_, open := <-updates
+	assert.False(t, open)
+
+	// Publishing after unsubscribing must not panic on the closed channel
+	hub.Publish(types.TicketStatusMessage{TicketID: "ticket-1", Status: "cancelled"})
+	unsubscribe()
+}
+
+func TestHubDropsForSlowSubscriber(t *testing.T) {
+	hub := NewHub()
+	updates, unsubscribe := hub.Subscribe("ticket-1")
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBuffer+5; i++ {
+		hub.Publish(types.TicketStatusMessage{TicketID: "ticket-1", Status: "pending"})
+	}
+	assert.Len(t, updates, subscriberBuffer)
+}
+
+func TestHubConsume(t *testing.T) {
+	hub := NewHub()
+	updates, unsubscribe := hub.Subscribe("ticket-1")
+	defer unsubscribe()
+
+	body, err := json.Marshal(types.TicketStatusMessage{TicketID: "ticket-1", Status: "payment_failed"})
+	require.NoError(t, err)
+
+	msgs := make(chan broker.Delivery, 2)
+	msgs <- broker.Delivery{Body: []byte("not json")}
+	msgs <- broker.Delivery{Body: body}
+	close(msgs)
+	hub.Consume(msgs)
+
+	msg := <-updates
+	assert.Equal(t, "payment_failed", msg.Status)
+}
+
+func TestEventCountersApply(t *testing.T) {
+	counters := EventCounters{EventID: "event-1"}
+	counters.Count("confirmed", 2, 200)
+	counters.Count("pending", 1, 100)
+
+	assert.True(t, counters.Apply(types.TicketStatusMessage{EventID: "event-1", Status: "pending", Quantity: 3, TotalPrice: 300}))
+	assert.True(t, counters.Apply(types.TicketStatusMessage{EventID: "event-1", Status: "confirmed", PreviousStatus: "pending", Quantity: 1, TotalPrice: 100}))
+	assert.True(t, counters.Apply(types.TicketStatusMessage{EventID: "event-1", Status: "refunded", PreviousStatus: "confirmed", Quantity: 2, TotalPrice: 200}))
+	assert.False(t, counters.Apply(types.TicketStatusMessage{EventID: "event-1", Status: "pending", PreviousStatus: "pending", Quantity: 3, TotalPrice: 300}))
+
+	assert.Equal(t, 1, counters.Sold)
+	assert.Equal(t, 3, counters.Pending)
+	assert.Equal(t, 2, counters.Refunded)
+	assert.InDelta(t, 100, counters.Revenue, 0.001)
+}
@@ -0,0 +1,59 @@
+package live
+
+import (
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+)
+
+// EventCounters are the live sales figures of an event. Ticket counts are
+// summed over ticket quantities, like the event stats endpoint. There is no
+// check-in counter: this service doesn't record check-ins, so there is no
+// status change to count them from.
+type EventCounters struct {
+	EventID   string    `json:"event_id"`
+	Sold      int       `json:"sold"`
+	Pending   int       `json:"pending"`
+	Cancelled int       `json:"cancelled"`
+	Refunded  int       `json:"refunded"`
+	Failed    int       `json:"failed"`
+	Revenue   float64   `json:"revenue"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Count adds a ticket in the given status to the counters
+func (c *EventCounters) Count(status string, quantity int, totalPrice float64) {
+	c.add(status, quantity, totalPrice)
+}
+
+// Apply moves a ticket from its previous status bucket to the new one. It
+// reports whether the counters changed.
+func (c *EventCounters) Apply(msg types.TicketStatusMessage) bool {
+	if msg.Status == msg.PreviousStatus {
+		return false
+	}
+	if msg.PreviousStatus != "" {
+		c.add(msg.PreviousStatus, -msg.Quantity, -msg.TotalPrice)
+	}
+	c.add(msg.Status, msg.Quantity, msg.TotalPrice)
+	if msg.Timestamp.After(c.UpdatedAt) {
+		c.UpdatedAt = msg.Timestamp
+	}
+	return true
+}
+
+func (c *EventCounters) add(status string, quantity int, totalPrice float64) {
+	switch status {
+	case "confirmed":
+		c.Sold += quantity
+		c.Revenue += totalPrice
+	case "pending":
+		c.Pending += quantity
+	case "cancelled":
+		c.Cancelled += quantity
+	case "refunded":
+		c.Refunded += quantity
+	case "payment_failed", "expired":
+		c.Failed += quantity
+	}
+}
@@ -47,6 +47,89 @@ var (
 		[]string{"action", "queue", "status", "service"},
 	)
 
+	// Time from publishing a RabbitMQ message until the broker confirmed it
+	RabbitMQConfirmDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rabbitmq_publish_confirm_duration_seconds",
+			Help:    "RabbitMQ publisher confirm latency in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"queue", "service"},
+	)
+
+	// Messages the consumer is currently processing
+	ConsumerInFlight = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "consumer_messages_in_flight",
+			Help: "Number of messages currently being processed",
+		},
+		[]string{"queue", "service"},
+	)
+
+	// Time spent processing a message, by outcome (success, failure)
+	ConsumerProcessingDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "consumer_message_processing_duration_seconds",
+			Help:    "Message processing latency in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"queue", "outcome", "service"},
+	)
+
+	// Messages handled by the consumer, by outcome (processed, failed)
+	ConsumerMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consumer_messages_total",
+			Help: "Total number of messages handled by the consumer",
+		},
+		[]string{"queue", "outcome", "service"},
+	)
+
+	// Failed messages scheduled for another attempt
+	ConsumerRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consumer_messages_retried_total",
+			Help: "Total number of failed messages scheduled for a retry",
+		},
+		[]string{"queue", "service"},
+	)
+
+	// When the consumer last finished a message, as a Unix timestamp
+	ConsumerLastMessage = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "consumer_last_message_timestamp_seconds",
+			Help: "Unix time the last message was handled",
+		},
+		[]string{"queue", "service"},
+	)
+
+	// Pending tickets handled by the sweeper, by outcome (recovered, expired)
+	PendingTicketsSwept = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pending_tickets_swept_total",
+			Help: "Total number of stale pending tickets recovered or expired",
+		},
+		[]string{"outcome", "service"},
+	)
+
+	// Webhook delivery attempts, by outcome (delivered, retrying, failed)
+	WebhookDeliveries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts",
+		},
+		[]string{"outcome", "service"},
+	)
+
+	// Outbox relay attempts, by outcome (published, retrying)
+	OutboxMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbox_messages_total",
+			Help: "Total number of outbox messages relayed to RabbitMQ",
+		},
+		[]string{"outcome", "service"},
+	)
+
 	// Database operations counter
 	DatabaseOperations = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -0,0 +1,98 @@
+package notifications_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/notifications"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/notifications/smtptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSender(t *testing.T, srv *smtptest.Server) *notifications.SMTPSender {
+	host, port, err := net.SplitHostPort(srv.Addr)
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return notifications.NewSMTPSender(configs.SMTPConfig{
+		Host: host,
+		Port: portNum,
+		From: "no-reply@dws.local",
+	})
+}
+
+func TestRender(t *testing.T) {
+	startsAt := time.Date(2026, 3, 1, 19, 0, 0, 0, time.UTC)
+	msg, err := notifications.Render(notifications.KindRescheduled, "jane@example.com", notifications.TemplateData{
+		BuyerName:     "Jane <Doe>",
+		TicketID:      "ticket-123",
+		EventName:     "Concert",
+		EventStartsAt: &startsAt,
+		Quantity:      2,
+		TotalPrice:    598,
+		Currency:      "SEK",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Concert has been rescheduled", msg.Subject)
+	assert.Contains(t, msg.Text, "Jane <Doe>")
+	assert.Contains(t, msg.HTML, "Jane &lt;Doe&gt;")
+	assert.Contains(t, msg.Text, "Sun, 01 Mar 2026 19:00 UTC")
+
+	_, err = notifications.Render("unknown", "jane@example.com", notifications.TemplateData{})
+	assert.Error(t, err)
+}
+
+func TestSendWithRetry(t *testing.T) {
+	srv, err := smtptest.NewServer()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	msg, err := notifications.Render(notifications.KindConfirmation, "jane@example.com", notifications.TemplateData{
+		BuyerName: "Jane",
+		TicketID:  "ticket-123",
+		EventName: "Concert",
+		Quantity:  1,
+	})
+	require.NoError(t, err)
+
+	srv.FailNext(1)
+	attempts, err := notifications.SendWithRetry(context.Background(), newSender(t, srv), msg, 3, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	mails := srv.Mails()
+	require.Len(t, mails, 1)
+	assert.Equal(t, []string{"jane@example.com"}, mails[0].To)
+	assert.Contains(t, mails[0].Data, "multipart/alternative")
+	assert.Contains(t, mails[0].Data, "Your tickets are confirmed")
+}
+
+func TestSendWithRetryGivesUp(t *testing.T) {
+	srv, err := smtptest.NewServer()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	srv.FailNext(5)
+	attempts, err := notifications.SendWithRetry(context.Background(), newSender(t, srv), notifications.Message{To: "jane@example.com"}, 3, time.Millisecond)
+	assert.Error(t, err)
+	assert.True(t, notifications.IsTransient(err))
+	assert.Equal(t, 3, attempts)
+	assert.Empty(t, srv.Mails())
+}
+
+func TestIsPermanent(t *testing.T) {
+	assert.True(t, notifications.IsPermanent(fmt.Errorf("send: %w", &textproto.Error{Code: 550, Msg: "mailbox unavailable"})))
+	assert.False(t, notifications.IsPermanent(&textproto.Error{Code: 451, Msg: "try again later"}))
+	assert.False(t, notifications.IsPermanent(errors.New("connection refused")))
+	assert.False(t, notifications.IsPermanent(context.Canceled))
+}
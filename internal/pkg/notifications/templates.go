@@ -0,0 +1,85 @@
+package notifications
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+)
+
+// Kind identifies a notification template
+type Kind string
+
+const (
+	KindConfirmation Kind = "confirmation"
+	KindCancellation Kind = "cancellation"
+	KindRefund       Kind = "refund"
+	KindRescheduled  Kind = "rescheduled"
+)
+
+var subjects = map[Kind]string{
+	KindConfirmation: "Your tickets for %s are confirmed",
+	KindCancellation: "Your tickets for %s have been cancelled",
+	KindRefund:       "Refund for %s",
+	KindRescheduled:  "%s has been rescheduled",
+}
+
+//go:embed templates/*.html templates/*.txt
+var templateFS embed.FS
+
+var funcs = map[string]any{
+	"formatTime": func(t *time.Time) string {
+		return t.UTC().Format("Mon, 02 Jan 2006 15:04 MST")
+	},
+}
+
+var (
+	htmlTemplates = make(map[Kind]*htmltemplate.Template)
+	textTemplates = make(map[Kind]*texttemplate.Template)
+)
+
+func init() {
+	for kind := range subjects {
+		htmlTemplates[kind] = htmltemplate.Must(htmltemplate.New(string(kind)+".html").Funcs(funcs).
+			ParseFS(templateFS, "templates/layout.html", "templates/"+string(kind)+".html"))
+		textTemplates[kind] = texttemplate.Must(texttemplate.New(string(kind)+".txt").Funcs(funcs).
+			ParseFS(templateFS, "templates/layout.txt", "templates/"+string(kind)+".txt"))
+	}
+}
+
+// TemplateData is the ticket information available to templates
+type TemplateData struct {
+	BuyerName     string
+	TicketID      string
+	EventName     string
+	EventStartsAt *time.Time
+	Quantity      int
+	TotalPrice    float64
+	Currency      string
+	Reason        string
+}
+
+// Render builds the email for a notification kind
+func Render(kind Kind, to string, data TemplateData) (Message, error) {
+	subject, ok := subjects[kind]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown notification kind: %s", kind)
+	}
+
+	var text, html bytes.Buffer
+	if err := textTemplates[kind].Execute(&text, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s text template: %w", kind, err)
+	}
+	if err := htmlTemplates[kind].Execute(&html, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s html template: %w", kind, err)
+	}
+
+	return Message{
+		To:      to,
+		Subject: fmt.Sprintf(subject, data.EventName),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
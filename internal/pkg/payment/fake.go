@@ -0,0 +1,117 @@
+package payment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// Test amounts for the fake provider, by the cents part of the amount, in the
+// spirit of card-network test numbers. Every other amount is approved.
+const (
+	fakeDeclinedCents          = 2
+	fakeInsufficientFundsCents = 5
+)
+
+// FakeProvider is a deterministic in-memory provider for local development
+// and tests. Payment IDs derive from the idempotency key.
+type FakeProvider struct {
+	mu       sync.Mutex
+	payments map[string]*Result
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		payments: make(map[string]*Result),
+	}
+}
+
+func (f *FakeProvider) Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := fakePaymentID(req.IdempotencyKey)
+	if existing, ok := f.payments[id]; ok {
+		result := *existing
+		return &result, nil
+	}
+
+	result := &Result{
+		ID:          id,
+		Status:      StatusAuthorized,
+		AmountCents: req.AmountCents,
+	}
+	switch req.AmountCents % 100 {
+	case fakeDeclinedCents:
+		result.Status = StatusDeclined
+		result.DeclineCode = "card_declined"
+		result.Message = "Your card was declined."
+	case fakeInsufficientFundsCents:
+		result.Status = StatusDeclined
+		result.DeclineCode = "insufficient_funds"
+		result.Message = "Your card has insufficient funds."
+	}
+
+	f.payments[id] = result
+	copied := *result
+	return &copied, nil
+}
+
+func (f *FakeProvider) Capture(ctx context.Context, paymentID string) (*Result, error) {
+	return f.transition(paymentID, StatusAuthorized, StatusCaptured)
+}
+
+func (f *FakeProvider) Refund(ctx context.Context, paymentID string, amountCents int64) (*Result, error) {
+	return f.transition(paymentID, StatusCaptured, StatusRefunded)
+}
+
+func (f *FakeProvider) Cancel(ctx context.Context, paymentID string) (*Result, error) {
+	return f.transition(paymentID, StatusAuthorized, StatusCancelled)
+}
+
+func (f *FakeProvider) Find(ctx context.Context, idempotencyKey string) (*Result, error) {
+	result, err := f.Status(ctx, fakePaymentID(idempotencyKey))
+	if err != nil {
+		return nil, ErrPaymentNotFound
+	}
+	return result, nil
+}
+
+func (f *FakeProvider) Status(ctx context.Context, paymentID string) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[paymentID]
+	if !ok {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+	result := *payment
+	return &result, nil
+}
+
+// transition moves a payment from one status to the next; repeating a
+// completed transition is a no-op so retries are safe.
+func (f *FakeProvider) transition(paymentID string, from, to Status) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[paymentID]
+	if !ok {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+	if payment.Status != from && payment.Status != to {
+		return nil, fmt.Errorf("payment %s is %s, expected %s", paymentID, payment.Status, from)
+	}
+
+	payment.Status = to
+	result := *payment
+	return &result, nil
+}
+
+// fakePaymentID derives the payment ID from the idempotency key
+func fakePaymentID(idempotencyKey string) string {
+	sum := sha256.Sum256([]byte(idempotencyKey))
+	return "fake_pi_" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+)
+
+// ErrPaymentNotFound is returned by Find when no payment was started with the
+// idempotency key
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// Status is the state of a payment at the provider
+type Status string
+
+const (
+	StatusAuthorized Status = "authorized"
+	StatusCaptured   Status = "captured"
+	StatusRefunded   Status = "refunded"
+	StatusDeclined   Status = "declined"
+	StatusCancelled  Status = "cancelled"
+	// StatusProcessing means the outcome isn't known yet, e.g. the payment is
+	// being processed or waits for the buyer to authenticate it. It may still
+	// succeed, so it must not be treated as a decline.
+	StatusProcessing Status = "processing"
+)
+
+// AuthorizeRequest describes a payment to reserve on the buyer's payment method
+type AuthorizeRequest struct {
+	// IdempotencyKey makes retries return the original payment instead of charging twice
+	IdempotencyKey string
+	AmountCents    int64
+	Currency       string
+	PaymentMethod  string
+	Description    string
+	Metadata       map[string]string
+}
+
+// Result is the provider's view of a payment after an operation. A declined
+// payment is a normal outcome, not an error; errors mean the outcome is unknown.
+type Result struct {
+	ID          string
+	Status      Status
+	AmountCents int64
+	DeclineCode string
+	Message     string
+}
+
+// Provider abstracts the payment gateway used by the consumer
+type Provider interface {
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error)
+	Capture(ctx context.Context, paymentID string) (*Result, error)
+	Refund(ctx context.Context, paymentID string, amountCents int64) (*Result, error)
+	// Cancel releases an authorized payment without capturing it
+	Cancel(ctx context.Context, paymentID string) (*Result, error)
+	Status(ctx context.Context, paymentID string) (*Result, error)
+	// Find returns the payment started with the idempotency key, or
+	// ErrPaymentNotFound
+	Find(ctx context.Context, idempotencyKey string) (*Result, error)
+}
+
+// NewProvider returns the provider selected by configuration
+func NewProvider(cfg *configs.Config) (Provider, error) {
+	switch cfg.Payment.Provider {
+	case "", "fake":
+		return NewFakeProvider(), nil
+	case "stripe":
+		return NewStripeProvider(cfg.Payment.Stripe), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider: %s", cfg.Payment.Provider)
+	}
+}
@@ -0,0 +1,136 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeProvider(t *testing.T) {
+	ctx := context.Background()
+	provider := NewFakeProvider()
+
+	auth, err := provider.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "ticket-1", AmountCents: 29900})
+	require.NoError(t, err)
+	assert.Equal(t, StatusAuthorized, auth.Status)
+
+	again, err := provider.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "ticket-1", AmountCents: 29900})
+	require.NoError(t, err)
+	assert.Equal(t, auth.ID, again.ID)
+
+	captured, err := provider.Capture(ctx, auth.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, captured.Status)
+
+	refunded, err := provider.Refund(ctx, auth.ID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRefunded, refunded.Status)
+
+	declined, err := provider.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "ticket-2", AmountCents: 1002})
+	require.NoError(t, err)
+	assert.Equal(t, StatusDeclined, declined.Status)
+	assert.Equal(t, "card_declined", declined.DeclineCode)
+
+	found, err := provider.Find(ctx, "ticket-1")
+	require.NoError(t, err)
+	assert.Equal(t, auth.ID, found.ID)
+	assert.Equal(t, StatusRefunded, found.Status)
+	_, err = provider.Find(ctx, "ticket-3")
+	assert.ErrorIs(t, err, ErrPaymentNotFound)
+
+	held, err := provider.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "ticket-4", AmountCents: 1000})
+	require.NoError(t, err)
+	cancelled, err := provider.Cancel(ctx, held.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, cancelled.Status)
+}
+
+func TestStripeProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer sk_test", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/v1/payment_intents":
+			assert.Equal(t, "ticket-1", r.Header.Get("Idempotency-Key"))
+			assert.NoError(t, r.ParseForm())
+			if r.PostForm.Get("payment_method") == "pm_card_chargeDeclined" {
+				w.WriteHeader(http.StatusPaymentRequired)
+				_, _ = w.Write([]byte(`{"error":{"type":"card_error","code":"card_declined","decline_code":"generic_decline","message":"Your card was declined.","payment_intent":{"id":"pi_2","status":"requires_payment_method","amount":1000}}}`))
+				return
+			}
+			if r.PostForm.Get("payment_method") == "pm_card_authenticationRequired" {
+				_, _ = w.Write([]byte(`{"id":"pi_3","status":"requires_action","amount":1000}`))
+				return
+			}
+			assert.Equal(t, "manual", r.PostForm.Get("capture_method"))
+			assert.Equal(t, "ticket-1", r.PostForm.Get("metadata[idempotency_key]"))
+			_, _ = w.Write([]byte(`{"id":"pi_1","status":"requires_capture","amount":1000}`))
+		case "/v1/payment_intents/pi_1/capture":
+			_, _ = w.Write([]byte(`{"id":"pi_1","status":"succeeded","amount":1000}`))
+		case "/v1/refunds":
+			assert.NoError(t, r.ParseForm())
+			if r.PostForm.Get("amount") == "" {
+				assert.Equal(t, "refund-pi_1-full", r.Header.Get("Idempotency-Key"))
+			} else {
+				assert.Equal(t, "refund-pi_1-"+r.PostForm.Get("amount"), r.Header.Get("Idempotency-Key"))
+			}
+			_, _ = w.Write([]byte(`{"id":"re_1","object":"refund","status":"succeeded","amount":1000}`))
+		case "/v1/payment_intents/pi_1":
+			// The refund is only visible on the expanded charge
+			assert.Equal(t, "latest_charge", r.URL.Query().Get("expand[]"))
+			_, _ = w.Write([]byte(`{"id":"pi_1","status":"succeeded","amount":1000,"latest_charge":{"id":"ch_1","amount_refunded":1000}}`))
+		case "/v1/payment_intents/search":
+			if r.URL.Query().Get("query") == "metadata['idempotency_key']:'ticket-1'" {
+				_, _ = w.Write([]byte(`{"data":[{"id":"pi_1","status":"succeeded","amount":1000}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":[]}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	provider := NewStripeProvider(configs.StripeConfig{BaseURL: srv.URL, SecretKey: "sk_test", DefaultPaymentMethod: "pm_card_visa"})
+	ctx := context.Background()
+
+	auth, err := provider.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "ticket-1", AmountCents: 1000, Currency: "SEK"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusAuthorized, auth.Status)
+
+	captured, err := provider.Capture(ctx, auth.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, captured.Status)
+
+	declined, err := provider.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "ticket-1", AmountCents: 1000, PaymentMethod: "pm_card_chargeDeclined"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusDeclined, declined.Status)
+	assert.Equal(t, "generic_decline", declined.DeclineCode)
+
+	// Waiting for the buyer to authenticate may still succeed
+	unsettled, err := provider.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "ticket-1", AmountCents: 1000, PaymentMethod: "pm_card_authenticationRequired"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusProcessing, unsettled.Status)
+
+	found, err := provider.Find(ctx, "ticket-1")
+	require.NoError(t, err)
+	assert.Equal(t, "pi_1", found.ID)
+	assert.Equal(t, StatusCaptured, found.Status)
+
+	refunded, err := provider.Refund(ctx, "pi_1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRefunded, refunded.Status)
+	_, err = provider.Refund(ctx, "pi_1", 500)
+	require.NoError(t, err)
+	_, err = provider.Find(ctx, "ticket-2")
+	assert.ErrorIs(t, err, ErrPaymentNotFound)
+
+	_, err = provider.Status(ctx, "pi_unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,267 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+)
+
+// StripeProvider talks to a Stripe-style payment intents API using manual
+// capture: Authorize creates and confirms an intent, Capture settles it.
+type StripeProvider struct {
+	baseURL       string
+	secretKey     string
+	paymentMethod string
+	httpClient    *http.Client
+}
+
+type stripePaymentIntent struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Amount int64  `json:"amount"`
+	// LatestCharge is the charge ID, or the charge itself when expanded;
+	// refunds are only recorded on the charge
+	LatestCharge     json.RawMessage `json:"latest_charge"`
+	LastPaymentError *struct {
+		DeclineCode string `json:"decline_code"`
+		Code        string `json:"code"`
+		Message     string `json:"message"`
+	} `json:"last_payment_error"`
+}
+
+type stripeError struct {
+	Error struct {
+		Type          string               `json:"type"`
+		Code          string               `json:"code"`
+		DeclineCode   string               `json:"decline_code"`
+		Message       string               `json:"message"`
+		PaymentIntent *stripePaymentIntent `json:"payment_intent"`
+	} `json:"error"`
+}
+
+func NewStripeProvider(cfg configs.StripeConfig) *StripeProvider {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &StripeProvider{
+		baseURL:       strings.TrimRight(cfg.BaseURL, "/"),
+		secretKey:     cfg.SecretKey,
+		paymentMethod: cfg.DefaultPaymentMethod,
+		httpClient:    &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *StripeProvider) Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error) {
+	paymentMethod := req.PaymentMethod
+	if paymentMethod == "" {
+		paymentMethod = s.paymentMethod
+	}
+
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(req.AmountCents, 10))
+	form.Set("currency", strings.ToLower(req.Currency))
+	form.Set("payment_method", paymentMethod)
+	form.Set("capture_method", "manual")
+	form.Set("confirm", "true")
+	if req.Description != "" {
+		form.Set("description", req.Description)
+	}
+	for k, v := range req.Metadata {
+		form.Set(fmt.Sprintf("metadata[%s]", k), v)
+	}
+	// Lets Find look the payment up when the outcome of a request is unknown
+	form.Set("metadata[idempotency_key]", req.IdempotencyKey)
+
+	return s.do(ctx, http.MethodPost, "/v1/payment_intents", form, req.IdempotencyKey)
+}
+
+func (s *StripeProvider) Capture(ctx context.Context, paymentID string) (*Result, error) {
+	return s.do(ctx, http.MethodPost, "/v1/payment_intents/"+url.PathEscape(paymentID)+"/capture", url.Values{}, "capture-"+paymentID)
+}
+
+// Refund refunds amountCents of a captured payment, or all of it with 0. The
+// idempotency key covers the payment and amount, so a retried refund is
+// deduplicated while a further partial refund of another amount is not.
+func (s *StripeProvider) Refund(ctx context.Context, paymentID string, amountCents int64) (*Result, error) {
+	form := url.Values{}
+	form.Set("payment_intent", paymentID)
+	idempotencyKey := "refund-" + paymentID + "-full"
+	if amountCents > 0 {
+		form.Set("amount", strconv.FormatInt(amountCents, 10))
+		idempotencyKey = fmt.Sprintf("refund-%s-%d", paymentID, amountCents)
+	}
+
+	raw, statusCode, err := s.send(ctx, http.MethodPost, "/v1/refunds", form, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode >= 300 {
+		return nil, fmt.Errorf("payment provider returned status %d: %s", statusCode, strings.TrimSpace(string(raw)))
+	}
+	return s.Status(ctx, paymentID)
+}
+
+func (s *StripeProvider) Cancel(ctx context.Context, paymentID string) (*Result, error) {
+	return s.do(ctx, http.MethodPost, "/v1/payment_intents/"+url.PathEscape(paymentID)+"/cancel", url.Values{}, "cancel-"+paymentID)
+}
+
+// Status expands the latest charge, which holds the refunded amount
+func (s *StripeProvider) Status(ctx context.Context, paymentID string) (*Result, error) {
+	return s.do(ctx, http.MethodGet, "/v1/payment_intents/"+url.PathEscape(paymentID)+"?expand[]=latest_charge", nil, "")
+}
+
+// Find searches the payment intents by the idempotency key stored in their
+// metadata. Search results can lag a few seconds behind new payments.
+func (s *StripeProvider) Find(ctx context.Context, idempotencyKey string) (*Result, error) {
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("metadata['idempotency_key']:'%s'", strings.ReplaceAll(idempotencyKey, "'", "\\'")))
+	query.Set("limit", "1")
+	query.Set("expand[]", "data.latest_charge")
+
+	raw, statusCode, err := s.send(ctx, http.MethodGet, "/v1/payment_intents/search?"+query.Encode(), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if statusCode >= 300 {
+		return nil, fmt.Errorf("payment provider returned status %d: %s", statusCode, strings.TrimSpace(string(raw)))
+	}
+
+	var found struct {
+		Data []stripePaymentIntent `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &found); err != nil {
+		return nil, fmt.Errorf("failed to decode payment search: %w", err)
+	}
+	if len(found.Data) == 0 {
+		return nil, ErrPaymentNotFound
+	}
+	return found.Data[0].toResult(), nil
+}
+
+// send performs a request and returns the raw response body and status
+func (s *StripeProvider) send(ctx context.Context, method, path string, form url.Values, idempotencyKey string) ([]byte, int, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build payment request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.secretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("payment request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read payment response: %w", err)
+	}
+	return raw, resp.StatusCode, nil
+}
+
+func (s *StripeProvider) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string) (*Result, error) {
+	raw, statusCode, err := s.send(ctx, method, path, form, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode == http.StatusPaymentRequired {
+		var apiErr stripeError
+		if err := json.Unmarshal(raw, &apiErr); err != nil {
+			return nil, fmt.Errorf("failed to decode payment error: %w", err)
+		}
+		result := &Result{
+			Status:      StatusDeclined,
+			DeclineCode: apiErr.Error.DeclineCode,
+			Message:     apiErr.Error.Message,
+		}
+		if result.DeclineCode == "" {
+			result.DeclineCode = apiErr.Error.Code
+		}
+		if pi := apiErr.Error.PaymentIntent; pi != nil {
+			result.ID = pi.ID
+			result.AmountCents = pi.Amount
+		}
+		return result, nil
+	}
+
+	if statusCode >= 300 {
+		return nil, fmt.Errorf("payment provider returned status %d: %s", statusCode, strings.TrimSpace(string(raw)))
+	}
+
+	var intent stripePaymentIntent
+	if err := json.Unmarshal(raw, &intent); err != nil {
+		return nil, fmt.Errorf("failed to decode payment response: %w", err)
+	}
+
+	return intent.toResult(), nil
+}
+
+func (pi *stripePaymentIntent) toResult() *Result {
+	result := &Result{
+		ID:          pi.ID,
+		AmountCents: pi.Amount,
+	}
+
+	switch pi.Status {
+	case "requires_capture":
+		result.Status = StatusAuthorized
+	case "succeeded":
+		result.Status = StatusCaptured
+		if pi.amountRefunded() > 0 {
+			result.Status = StatusRefunded
+		}
+	case "canceled":
+		result.Status = StatusCancelled
+	case "processing", "requires_action", "requires_confirmation":
+		result.Status = StatusProcessing
+	default:
+		// requires_payment_method: the authorization did not go through
+		result.Status = StatusDeclined
+		if pi.LastPaymentError != nil {
+			result.DeclineCode = pi.LastPaymentError.DeclineCode
+			if result.DeclineCode == "" {
+				result.DeclineCode = pi.LastPaymentError.Code
+			}
+			result.Message = pi.LastPaymentError.Message
+		}
+	}
+
+	return result
+}
+
+// amountRefunded is the refunded amount of the expanded latest charge, 0 if
+// the charge isn't expanded
+func (pi *stripePaymentIntent) amountRefunded() int64 {
+	var charge struct {
+		AmountRefunded int64 `json:"amount_refunded"`
+	}
+	if len(pi.LatestCharge) == 0 || pi.LatestCharge[0] != '{' {
+		return 0
+	}
+	if err := json.Unmarshal(pi.LatestCharge, &charge); err != nil {
+		return 0
+	}
+	return charge.AmountRefunded
+}
@@ -0,0 +1,133 @@
+package rabbitmq
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrNacked is returned when the broker refuses a published message
+	ErrNacked = errors.New("message was nacked by the broker")
+	// ErrUnroutable is returned when no queue is bound for a mandatory message
+	ErrUnroutable = broker.ErrUnroutable
+	// ErrConfirmTimeout is returned when the broker doesn't confirm a message in time
+	ErrConfirmTimeout = errors.New("timed out waiting for publisher confirm")
+	// errChannelClosed fails publishes still waiting when the channel closes
+	errChannelClosed = errors.New("channel closed before the message was confirmed")
+)
+
+// confirmTracker matches publisher confirms and returns to the publishes
+// waiting for them. Delivery tags are assigned by the channel in publish
+// order, so callers register the tag they expect while holding the lock
+// that serialises their publishes.
+type confirmTracker struct {
+	mu        sync.Mutex
+	published uint64
+	pending   map[uint64]*pendingConfirm
+	returned  map[string]amqp.Return
+}
+
+type pendingConfirm struct {
+	messageID string
+	done      chan error
+}
+
+func newConfirmTracker() *confirmTracker {
+	return &confirmTracker{
+		pending:  make(map[uint64]*pendingConfirm),
+		returned: make(map[string]amqp.Return),
+	}
+}
+
+// listen resolves pending publishes until the channel closes. The channel
+// delivers a message's basic.return before its ack on the same goroutine,
+// and both notification channels are unbuffered, so the return is always
+// recorded before the ack is handled.
+func (t *confirmTracker) listen(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			t.mu.Lock()
+			t.returned[ret.MessageId] = ret
+			t.mu.Unlock()
+		case confirm, ok := <-confirms:
+			if !ok {
+				t.failAll(errChannelClosed)
+				return
+			}
+			t.resolve(confirm)
+		}
+	}
+}
+
+// expect registers the next delivery tag for a message about to be
+// published. Callers hold the publish lock and call sent or forget
+// once the publish returns.
+func (t *confirmTracker) expect(messageID string) (uint64, <-chan error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tag := t.published + 1
+	done := make(chan error, 1)
+	t.pending[tag] = &pendingConfirm{messageID: messageID, done: done}
+	return tag, done
+}
+
+// sent records that the publish with the expected tag went out
+func (t *confirmTracker) sent() {
+	t.mu.Lock()
+	t.published++
+	t.mu.Unlock()
+}
+
+// forget drops a publish that failed or stopped waiting
+func (t *confirmTracker) forget(tag uint64) {
+	t.mu.Lock()
+	if p, ok := t.pending[tag]; ok {
+		delete(t.returned, p.messageID)
+		delete(t.pending, tag)
+	}
+	t.mu.Unlock()
+}
+
+func (t *confirmTracker) resolve(confirm amqp.Confirmation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.pending[confirm.DeliveryTag]
+	if !ok {
+		// The publisher gave up waiting
+		return
+	}
+	delete(t.pending, confirm.DeliveryTag)
+
+	_, returned := t.returned[p.messageID]
+	delete(t.returned, p.messageID)
+
+	switch {
+	case !confirm.Ack:
+		p.done <- ErrNacked
+	case returned:
+		p.done <- ErrUnroutable
+	default:
+		p.done <- nil
+	}
+}
+
+func (t *confirmTracker) failAll(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for tag, p := range t.pending {
+		p.done <- err
+		delete(t.pending, tag)
+	}
+	t.returned = make(map[string]amqp.Return)
+}
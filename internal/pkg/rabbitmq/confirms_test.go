@@ -0,0 +1,48 @@
+package rabbitmq
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirmTracker(t *testing.T) {
+	tracker := newConfirmTracker()
+	confirms := make(chan amqp.Confirmation)
+	returns := make(chan amqp.Return)
+	go tracker.listen(confirms, returns)
+
+	tag1, acked := tracker.expect("msg-1")
+	tracker.sent()
+	tag2, nacked := tracker.expect("msg-2")
+	tracker.sent()
+	tag3, unroutable := tracker.expect("msg-3")
+	tracker.sent()
+	assert.Equal(t, []uint64{1, 2, 3}, []uint64{tag1, tag2, tag3})
+
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: false}
+	returns <- amqp.Return{MessageId: "msg-3"}
+	confirms <- amqp.Confirmation{DeliveryTag: 3, Ack: true}
+
+	assert.NoError(t, <-acked)
+	assert.ErrorIs(t, <-nacked, ErrNacked)
+	assert.ErrorIs(t, <-unroutable, ErrUnroutable)
+}
+
+func TestConfirmTrackerForget(t *testing.T) {
+	tracker := newConfirmTracker()
+
+	// A failed publish doesn't consume a delivery tag
+	tag, _ := tracker.expect("failed")
+	tracker.forget(tag)
+	next, done := tracker.expect("next")
+	assert.Equal(t, tag, next)
+	tracker.sent()
+
+	confirms := make(chan amqp.Confirmation)
+	go tracker.listen(confirms, make(chan amqp.Return))
+	close(confirms)
+	assert.ErrorIs(t, <-done, errChannelClosed)
+}
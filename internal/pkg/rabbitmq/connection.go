@@ -0,0 +1,350 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/ids"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// ErrNotConnected is returned by publishes while the connection is down
+var ErrNotConnected = errors.New("RabbitMQ is not connected")
+
+// errServiceClosed aborts a reconnect that raced with Close
+var errServiceClosed = errors.New("RabbitMQ service is closed")
+
+const (
+	reconnectBaseBackoff = time.Second
+	// resubscribeBackoff spaces out attempts to register a consumer on a
+	// channel that turned out to be closed
+	resubscribeBackoff = time.Second
+)
+
+// connect dials the broker, declares the exchanges and queues and swaps in
+// the new connection. The returned channels receive when the connection or
+// the channel closes.
+func (r *RabbitMQService) connect() (<-chan *amqp.Error, <-chan *amqp.Error, error) {
+	conn, err := amqp.Dial(r.config.URL)
+	if err != nil {
+		log.WithError(err).Error("RabbitMQ connection failed")
+		return nil, nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		log.WithError(err).Error("RabbitMQ channel creation failed")
+		return nil, nil, fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+
+	// Bound the unacked deliveries each consumer on the channel is handed
+	if err := channel.Qos(r.appConfig.Consumer.PrefetchCount, 0, false); err != nil {
+		conn.Close()
+		log.WithError(err).Error("RabbitMQ prefetch setup failed")
+		return nil, nil, fmt.Errorf("failed to set prefetch count: %w", err)
+	}
+
+	// Publisher confirms let publishes wait for the broker to take over
+	confirms, err := enableConfirms(channel)
+	if err != nil {
+		conn.Close()
+		log.WithError(err).Error("RabbitMQ confirm mode failed")
+		return nil, nil, err
+	}
+
+	// Declare exchange
+	if err := channel.ExchangeDeclare(
+		r.config.Exchange,
+		"topic",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		conn.Close()
+		log.WithError(err).Error("RabbitMQ exchange declaration failed")
+		return nil, nil, fmt.Errorf("failed to declare exchange: %w", err)
+	}
+
+	// Declare queues
+	if err := declareQueues(channel, r.appConfig); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chanClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		conn.Close()
+		return nil, nil, errServiceClosed
+	}
+	r.conn = conn
+	r.channel = channel
+	r.confirms = confirms
+	r.isHealthy = true
+	close(r.ready)
+
+	return connClosed, chanClosed, nil
+}
+
+// supervise waits for the connection or channel to close and reconnects
+// with exponential backoff until the service is closed. Consumers registered
+// through subscribe pick up the new channel on their own.
+func (r *RabbitMQService) supervise(connClosed, chanClosed <-chan *amqp.Error) {
+	for {
+		var reason *amqp.Error
+		select {
+		case <-r.done:
+			return
+		case reason = <-connClosed:
+		case reason = <-chanClosed:
+		}
+
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return
+		}
+		log.WithField("reason", reason).Error("RabbitMQ connection lost, reconnecting")
+		// A channel can fail on its own; drop the whole connection so both
+		// are set up again
+		if r.conn != nil {
+			r.conn.Close()
+		}
+		r.conn = nil
+		r.channel = nil
+		r.confirms = nil
+		r.isHealthy = false
+		r.ready = make(chan struct{})
+		r.mu.Unlock()
+
+		var err error
+		connClosed, chanClosed, err = r.reconnect()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// reconnect retries connect until it succeeds or the service is closed
+func (r *RabbitMQService) reconnect() (<-chan *amqp.Error, <-chan *amqp.Error, error) {
+	maxBackoff := time.Duration(r.config.ReconnectMaxBackoffSeconds) * time.Second
+	backoff := reconnectBaseBackoff
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-r.done:
+			return nil, nil, errServiceClosed
+		case <-time.After(backoff):
+		}
+
+		connClosed, chanClosed, err := r.connect()
+		if err == nil {
+			log.WithField("attempt", attempt).Info("Reconnected to RabbitMQ")
+			return connClosed, chanClosed, nil
+		}
+		if errors.Is(err, errServiceClosed) {
+			return nil, nil, err
+		}
+
+		log.WithError(err).WithFields(log.Fields{
+			"attempt": attempt,
+			"backoff": backoff,
+		}).Warn("Failed to reconnect to RabbitMQ")
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// subscription is a consumer that is registered again after reconnects
+// until it is cancelled
+type subscription struct {
+	name     string
+	tag      string
+	register func(channel *amqp.Channel, tag string) (<-chan amqp.Delivery, error)
+	// stop is closed by CancelConsumers
+	stop chan struct{}
+}
+
+// subscribe registers a consumer and keeps it registered across reconnects.
+// The returned channel stays open until the consumer is cancelled or the
+// service is closed. Deliveries that were unacked when the connection was
+// lost are redelivered by the broker; acking them on the old channel fails.
+func (r *RabbitMQService) subscribe(name string, register func(channel *amqp.Channel, tag string) (<-chan amqp.Delivery, error)) (<-chan broker.Delivery, error) {
+	r.mu.Lock()
+	sub := &subscription{
+		name:     name,
+		tag:      fmt.Sprintf("%s-%s", r.serviceName, ids.NewUUID()),
+		register: register,
+		stop:     make(chan struct{}),
+	}
+	channel := r.channel
+	if channel != nil {
+		r.subscriptions = append(r.subscriptions, sub)
+	}
+	r.mu.Unlock()
+
+	if channel == nil {
+		return nil, ErrNotConnected
+	}
+
+	msgs, err := register(channel, sub.tag)
+	if err != nil {
+		r.removeSubscription(sub)
+		return nil, err
+	}
+
+	out := make(chan broker.Delivery)
+	go r.forward(sub, msgs, out)
+	return out, nil
+}
+
+// CancelConsumers stops the broker from sending further deliveries. The
+// delivery channels close once the deliveries already received have been
+// read, so callers can finish them before closing the connection.
+func (r *RabbitMQService) CancelConsumers() error {
+	r.mu.Lock()
+	subs := r.subscriptions
+	r.subscriptions = nil
+	channel := r.channel
+	r.mu.Unlock()
+
+	var errs []error
+	for _, sub := range subs {
+		close(sub.stop)
+		if channel == nil {
+			continue
+		}
+		if err := channel.Cancel(sub.tag, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to cancel consumer %s: %w", sub.name, err))
+			continue
+		}
+		log.WithField("consumer", sub.name).Info("Consumer cancelled")
+	}
+	return errors.Join(errs...)
+}
+
+func (r *RabbitMQService) removeSubscription(sub *subscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, s := range r.subscriptions {
+		if s == sub {
+			r.subscriptions = append(r.subscriptions[:i], r.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *RabbitMQService) forward(sub *subscription, msgs <-chan amqp.Delivery, out chan<- broker.Delivery) {
+	defer close(out)
+
+	for {
+		for msg := range msgs {
+			out <- toDelivery(msg)
+		}
+
+		// The channel closed; wait for the supervisor to reconnect unless
+		// the consumer was cancelled
+		for {
+			channel, ok := r.waitForChannel(sub.stop)
+			if !ok {
+				return
+			}
+
+			var err error
+			if msgs, err = sub.register(channel, sub.tag); err == nil {
+				log.WithField("consumer", sub.name).Info("Consumer re-registered after reconnect")
+				break
+			}
+			log.WithError(err).WithField("consumer", sub.name).Warn("Failed to re-register consumer")
+
+			select {
+			case <-r.done:
+				return
+			case <-sub.stop:
+				return
+			case <-time.After(resubscribeBackoff):
+			}
+		}
+	}
+}
+
+// waitForChannel blocks until a channel is available, or returns false once
+// stop is closed or the service is closed
+func (r *RabbitMQService) waitForChannel(stop <-chan struct{}) (*amqp.Channel, bool) {
+	for {
+		select {
+		case <-stop:
+			return nil, false
+		default:
+		}
+
+		r.mu.RLock()
+		channel, ready, closed := r.channel, r.ready, r.closed
+		r.mu.RUnlock()
+
+		if closed {
+			return nil, false
+		}
+		if channel != nil {
+			return channel, true
+		}
+
+		select {
+		case <-r.done:
+			return nil, false
+		case <-stop:
+			return nil, false
+		case <-ready:
+		}
+	}
+}
+
+// toDelivery converts an AMQP delivery. Auto-acked deliveries have no
+// acknowledger.
+func toDelivery(msg amqp.Delivery) broker.Delivery {
+	delivery := broker.Delivery{
+		MessageID:     msg.MessageId,
+		CorrelationID: msg.CorrelationId,
+		Type:          msg.Type,
+		AppID:         msg.AppId,
+		ContentType:   msg.ContentType,
+		Timestamp:     msg.Timestamp,
+		Headers:       msg.Headers,
+		Body:          msg.Body,
+		Exchange:      msg.Exchange,
+		RoutingKey:    msg.RoutingKey,
+		Redelivered:   msg.Redelivered,
+		DeliveryTag:   msg.DeliveryTag,
+	}
+	if msg.Acknowledger != nil {
+		delivery.Acknowledger = acknowledger{msg.Acknowledger}
+	}
+	return delivery
+}
+
+// acknowledger settles single deliveries on their AMQP channel
+type acknowledger struct {
+	channel amqp.Acknowledger
+}
+
+func (a acknowledger) Ack(tag uint64) error {
+	return a.channel.Ack(tag, false)
+}
+
+func (a acknowledger) Nack(tag uint64, requeue bool) error {
+	return a.channel.Nack(tag, false, requeue)
+}
@@ -0,0 +1,149 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeSurvivesReconnect(t *testing.T) {
+	r := &RabbitMQService{
+		channel: &amqp.Channel{},
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	close(r.ready)
+
+	sources := make(chan chan amqp.Delivery, 2)
+	first, second := make(chan amqp.Delivery, 1), make(chan amqp.Delivery, 1)
+	sources <- first
+	sources <- second
+
+	msgs, err := r.subscribe("test", func(*amqp.Channel, string) (<-chan amqp.Delivery, error) {
+		return <-sources, nil
+	})
+	require.NoError(t, err)
+
+	first <- amqp.Delivery{MessageId: "before"}
+	assert.Equal(t, "before", receive(t, msgs).MessageID)
+
+	// Connection lost: the old delivery channel closes
+	r.mu.Lock()
+	r.channel = nil
+	r.ready = make(chan struct{})
+	r.mu.Unlock()
+	close(first)
+
+	// Reconnected: the consumer is registered on the new channel
+	r.mu.Lock()
+	r.channel = &amqp.Channel{}
+	close(r.ready)
+	r.mu.Unlock()
+
+	second <- amqp.Delivery{MessageId: "after"}
+	assert.Equal(t, "after", receive(t, msgs).MessageID)
+
+	// Closing the service ends the subscription
+	r.mu.Lock()
+	r.closed = true
+	close(r.done)
+	r.mu.Unlock()
+	close(second)
+
+	select {
+	case _, ok := <-msgs:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not closed")
+	}
+}
+
+func TestCancelConsumersStopsResubscribing(t *testing.T) {
+	r := &RabbitMQService{
+		channel: &amqp.Channel{},
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	close(r.ready)
+
+	source := make(chan amqp.Delivery, 1)
+	registered := 0
+	msgs, err := r.subscribe("test", func(*amqp.Channel, string) (<-chan amqp.Delivery, error) {
+		registered++
+		return source, nil
+	})
+	require.NoError(t, err)
+
+	// Cancel while disconnected, as during a shutdown racing a broker restart
+	r.mu.Lock()
+	r.channel = nil
+	r.ready = make(chan struct{})
+	r.mu.Unlock()
+	require.NoError(t, r.CancelConsumers())
+
+	source <- amqp.Delivery{MessageId: "in-flight"}
+	close(source)
+	assert.Equal(t, "in-flight", receive(t, msgs).MessageID)
+
+	select {
+	case _, ok := <-msgs:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not closed")
+	}
+	assert.Equal(t, 1, registered)
+}
+
+func TestPublishFailsFastWhileDisconnected(t *testing.T) {
+	r := &RabbitMQService{ready: make(chan struct{}), done: make(chan struct{})}
+
+	err := r.publishBody(context.Background(), "ticket_exchange", "ticket.purchased", true, amqp.Publishing{})
+	assert.ErrorIs(t, err, ErrNotConnected)
+}
+
+func TestToDeliverySettlesOnItsChannel(t *testing.T) {
+	acker := &recordingAcknowledger{}
+	msg := toDelivery(amqp.Delivery{MessageId: "msg-1", DeliveryTag: 7, Acknowledger: acker})
+
+	require.NoError(t, msg.Ack())
+	require.NoError(t, msg.Nack(true))
+	assert.Equal(t, []string{"ack 7", "nack 7 requeue=true"}, acker.calls)
+
+	autoAcked := toDelivery(amqp.Delivery{MessageId: "msg-2"})
+	assert.Error(t, autoAcked.Ack())
+}
+
+type recordingAcknowledger struct {
+	calls []string
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.calls = append(a.calls, fmt.Sprintf("ack %d", tag))
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.calls = append(a.calls, fmt.Sprintf("nack %d requeue=%t", tag, requeue))
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func receive(t *testing.T, msgs <-chan broker.Delivery) broker.Delivery {
+	t.Helper()
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no delivery received")
+		return broker.Delivery{}
+	}
+}
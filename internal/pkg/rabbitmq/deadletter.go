@@ -0,0 +1,136 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/streadway/amqp"
+)
+
+// DeadLetters reads messages off queue's dead-letter queue without acking
+// them; closing the inspection channel returns them in their original order
+func (r *RabbitMQService) DeadLetters(queue string, limit int) ([]broker.Delivery, error) {
+	if err := broker.CheckDeadLetterQueue(r.config, queue); err != nil {
+		return nil, err
+	}
+
+	channel, err := r.inspectionChannel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	return getDeadLetters(channel, queue, limit)
+}
+
+// ReplayDeadLetters publishes copies of the selected dead-lettered messages
+// onto queue through the default exchange, acking each once its copy is
+// confirmed. The other messages stay in the dead-letter queue.
+func (r *RabbitMQService) ReplayDeadLetters(queue string, messageIDs []string) (int, error) {
+	if err := broker.CheckDeadLetterQueue(r.config, queue); err != nil {
+		return 0, err
+	}
+
+	channel, err := r.inspectionChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer channel.Close()
+
+	msgs, err := getDeadLetters(channel, queue, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := broker.MessageIDSet(messageIDs)
+	replayed := 0
+	for _, msg := range msgs {
+		if !ids[msg.MessageID] {
+			continue
+		}
+		if err := r.publishBody(context.Background(), "", queue, true, republishing(msg, broker.ReplayHeaders(msg))); err != nil {
+			return replayed, fmt.Errorf("failed to replay message %s: %w", msg.MessageID, err)
+		}
+		if err := msg.Ack(); err != nil {
+			// Replayed, but stays dead-lettered as well
+			return replayed, fmt.Errorf("failed to remove replayed message %s: %w", msg.MessageID, err)
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// PurgeDeadLetters acks the selected dead-lettered messages, or purges the
+// whole dead-letter queue without IDs
+func (r *RabbitMQService) PurgeDeadLetters(queue string, messageIDs []string) (int, error) {
+	if err := broker.CheckDeadLetterQueue(r.config, queue); err != nil {
+		return 0, err
+	}
+
+	channel, err := r.inspectionChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer channel.Close()
+
+	if len(messageIDs) == 0 {
+		return channel.QueuePurge(broker.DeadLetterQueueName(queue), false)
+	}
+
+	msgs, err := getDeadLetters(channel, queue, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := broker.MessageIDSet(messageIDs)
+	purged := 0
+	for _, msg := range msgs {
+		if !ids[msg.MessageID] {
+			continue
+		}
+		if err := msg.Ack(); err != nil {
+			return purged, fmt.Errorf("failed to purge message %s: %w", msg.MessageID, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// inspectionChannel opens a channel of its own, so the unacked messages it
+// holds are returned to their queue when it is closed
+func (r *RabbitMQService) inspectionChannel() (*amqp.Channel, error) {
+	r.mu.RLock()
+	conn := r.conn
+	r.mu.RUnlock()
+
+	if conn == nil || conn.IsClosed() {
+		return nil, fmt.Errorf("RabbitMQ connection is not healthy")
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	return channel, nil
+}
+
+// getDeadLetters gets up to limit messages (all with 0) off queue's
+// dead-letter queue. Messages held unacked aren't handed out again, so
+// reading stops once the queue is empty.
+func getDeadLetters(channel *amqp.Channel, queue string, limit int) ([]broker.Delivery, error) {
+	dlq := broker.DeadLetterQueueName(queue)
+
+	var msgs []broker.Delivery
+	for limit <= 0 || len(msgs) < limit {
+		msg, ok, err := channel.Get(dlq, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read queue %s: %w", dlq, err)
+		}
+		if !ok {
+			break
+		}
+		msgs = append(msgs, toDelivery(msg))
+	}
+	return msgs, nil
+}
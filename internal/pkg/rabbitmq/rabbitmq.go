@@ -1,12 +1,18 @@
 package rabbitmq
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/ids"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/metrics"
 	"github.com/oskargbc/dws-ticket-service/internal/types"
 	log "github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
@@ -17,64 +23,52 @@ var (
 	rabbitOnce     sync.Once
 )
 
+var _ broker.Broker = (*RabbitMQService)(nil)
+
 type RabbitMQService struct {
 	conn     *amqp.Connection
 	channel  *amqp.Channel
 	config   *configs.RabbitMQConfig
 	mu       sync.RWMutex
 	isHealthy bool
+
+	// publishMu serialises publishes so delivery tags match confirms
+	publishMu   sync.Mutex
+	confirms    *confirmTracker
+	serviceName string
+
+	// appConfig is kept to redeclare the topology on reconnect
+	appConfig *configs.Config
+	// ready is closed while connected and replaced when the connection is lost
+	ready  chan struct{}
+	done   chan struct{}
+	closed bool
+	// subscriptions are the consumers to re-register after a reconnect
+	subscriptions []*subscription
 }
 
+// GetRabbitMQServiceInstance connects to RabbitMQ and starts a supervisor
+// that reconnects whenever the connection or channel is lost
 func GetRabbitMQServiceInstance(cfg *configs.Config) (*RabbitMQService, error) {
 	var err error
 	rabbitOnce.Do(func() {
-		conn, connErr := amqp.Dial(cfg.RabbitMQ.URL)
-		if connErr != nil {
-			err = fmt.Errorf("failed to connect to RabbitMQ: %w", connErr)
-			log.WithError(connErr).Error("RabbitMQ connection failed")
-			return
+		r := &RabbitMQService{
+			config:      &cfg.RabbitMQ,
+			serviceName: "dws-ticket-service",
+			appConfig:   cfg,
+			ready:       make(chan struct{}),
+			done:        make(chan struct{}),
 		}
 
-		channel, chanErr := conn.Channel()
-		if chanErr != nil {
-			conn.Close()
-			err = fmt.Errorf("failed to open RabbitMQ channel: %w", chanErr)
-			log.WithError(chanErr).Error("RabbitMQ channel creation failed")
-			return
-		}
-
-		// Declare exchange
-		if exchErr := channel.ExchangeDeclare(
-			cfg.RabbitMQ.Exchange,
-			"topic",
-			true,
-			false,
-			false,
-			false,
-			nil,
-		); exchErr != nil {
-			channel.Close()
-			conn.Close()
-			err = fmt.Errorf("failed to declare exchange: %w", exchErr)
-			log.WithError(exchErr).Error("RabbitMQ exchange declaration failed")
-			return
-		}
-
-		// Declare queues
-		if queueErr := declareQueues(channel, cfg); queueErr != nil {
-			channel.Close()
-			conn.Close()
-			err = queueErr
+		connClosed, chanClosed, connErr := r.connect()
+		if connErr != nil {
+			err = connErr
 			return
 		}
 
 		log.Info("Successfully connected to RabbitMQ")
-		rabbitInstance = &RabbitMQService{
-			conn:      conn,
-			channel:   channel,
-			config:    &cfg.RabbitMQ,
-			isHealthy: true,
-		}
+		go r.supervise(connClosed, chanClosed)
+		rabbitInstance = r
 	})
 
 	if err != nil {
@@ -84,10 +78,35 @@ func GetRabbitMQServiceInstance(cfg *configs.Config) (*RabbitMQService, error) {
 	return rabbitInstance, nil
 }
 
+// enableConfirms puts the channel in confirm mode and starts matching
+// confirms and returns to publishes
+func enableConfirms(channel *amqp.Channel) (*confirmTracker, error) {
+	if err := channel.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	tracker := newConfirmTracker()
+	go tracker.listen(
+		channel.NotifyPublish(make(chan amqp.Confirmation)),
+		channel.NotifyReturn(make(chan amqp.Return)),
+	)
+	return tracker, nil
+}
+
+// SetServiceName sets the service label of the metrics recorded for publishes
+func (r *RabbitMQService) SetServiceName(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.serviceName = name
+}
+
 func declareQueues(channel *amqp.Channel, cfg *configs.Config) error {
 	queues := []string{
 		cfg.RabbitMQ.Queue.Purchased,
 		cfg.RabbitMQ.Queue.Confirmed,
+		cfg.RabbitMQ.Queue.PaymentFailed,
+		cfg.RabbitMQ.Queue.Notification,
 	}
 
 	for _, queueName := range queues {
@@ -115,37 +134,76 @@ func declareQueues(channel *amqp.Channel, cfg *configs.Config) error {
 		}
 	}
 
-	return nil
-}
-
-func (r *RabbitMQService) PublishTicketPurchased(msg types.TicketMessage) error {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	if err := declareEventLifecycleQueue(channel, cfg); err != nil {
+		return err
+	}
 
-	if r.channel == nil {
-		return fmt.Errorf("RabbitMQ channel is nil")
+	if err := declareRetryQueues(channel, &cfg.RabbitMQ); err != nil {
+		return err
 	}
 
-	body, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+	// Status updates are fanned out to a private queue per API replica
+	if err := channel.ExchangeDeclare(
+		cfg.RabbitMQ.StatusExchange,
+		"fanout",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", cfg.RabbitMQ.StatusExchange, err)
 	}
 
-	if err := r.channel.Publish(
-		r.config.Exchange,
-		r.config.Queue.Purchased,
+	return nil
+}
+
+// declareEventLifecycleQueue binds this service's lifecycle queue to the event
+// service's topic exchange. Declaring the exchange is idempotent and lets the
+// consumer start before dws-event-service has published anything.
+func declareEventLifecycleQueue(channel *amqp.Channel, cfg *configs.Config) error {
+	if err := channel.ExchangeDeclare(
+		cfg.RabbitMQ.EventExchange,
+		"topic",
+		true,
+		false,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType:  "application/json",
-			Body:         body,
-			DeliveryMode: amqp.Persistent,
-			Timestamp:    time.Now(),
-		},
+		nil,
 	); err != nil {
-		log.WithError(err).Error("Failed to publish message to RabbitMQ")
-		r.isHealthy = false
-		return fmt.Errorf("failed to publish message: %w", err)
+		return fmt.Errorf("failed to declare exchange %s: %w", cfg.RabbitMQ.EventExchange, err)
+	}
+
+	queue, err := channel.QueueDeclare(
+		cfg.RabbitMQ.Queue.EventLifecycle,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", cfg.RabbitMQ.Queue.EventLifecycle, err)
+	}
+
+	for _, routingKey := range []string{broker.RoutingKeyEventCancelled, broker.RoutingKeyEventRescheduled} {
+		if err := channel.QueueBind(
+			queue.Name,
+			routingKey,
+			cfg.RabbitMQ.EventExchange,
+			false,
+			nil,
+		); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %w", queue.Name, routingKey, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *RabbitMQService) PublishTicketPurchased(ctx context.Context, msg types.TicketMessage) error {
+	if err := r.publish(ctx, r.config.Queue.Purchased, msg.TicketID, msg); err != nil {
+		return err
 	}
 
 	log.WithFields(log.Fields{
@@ -157,33 +215,280 @@ func (r *RabbitMQService) PublishTicketPurchased(msg types.TicketMessage) error
 	return nil
 }
 
-func (r *RabbitMQService) ConsumeTicketPurchased() (<-chan amqp.Delivery, error) {
+// PublishTicketConfirmed announces a confirmed ticket to downstream services
+func (r *RabbitMQService) PublishTicketConfirmed(ctx context.Context, msg types.TicketConfirmedMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	if err := r.publishEvent(ctx, r.config.Exchange, r.config.Queue.Confirmed, true, envelope.Envelope{
+		ID:      broker.ConfirmedMessageID(msg.TicketID),
+		Subject: msg.TicketID,
+	}, body); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id":  msg.TicketID,
+		"event_id":   msg.EventID,
+		"payment_id": msg.PaymentID,
+	}).Info("Published ticket confirmed message")
+
+	return nil
+}
+
+func (r *RabbitMQService) PublishTicketPaymentFailed(ctx context.Context, msg types.TicketPaymentFailedMessage) error {
+	if err := r.publish(ctx, r.config.Queue.PaymentFailed, msg.TicketID, msg); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id":   msg.TicketID,
+		"event_id":    msg.EventID,
+		"reason_code": msg.ReasonCode,
+	}).Info("Published ticket payment failed message")
+
+	return nil
+}
+
+func (r *RabbitMQService) PublishTicketNotification(ctx context.Context, msg types.TicketNotificationMessage) error {
+	if err := r.publish(ctx, r.config.Queue.Notification, msg.TicketID, msg); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id": msg.TicketID,
+		"type":      msg.Type,
+	}).Info("Published ticket notification message")
+
+	return nil
+}
+
+// PublishTicketStatus fans a ticket status change out to all API replicas
+func (r *RabbitMQService) PublishTicketStatus(ctx context.Context, msg types.TicketStatusMessage) error {
+	// Not mandatory: no API replica listening is not an error
+	if err := r.publishTo(ctx, r.config.StatusExchange, "", false, msg.TicketID, msg); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id": msg.TicketID,
+		"status":    msg.Status,
+	}).Debug("Published ticket status message")
+
+	return nil
+}
+
+// publish sends a persistent JSON message about subject to the exchange with
+// the given routing key. The message is mandatory: one that no queue is bound
+// for is an error rather than silently dropped.
+func (r *RabbitMQService) publish(ctx context.Context, routingKey, subject string, msg interface{}) error {
+	return r.publishTo(ctx, r.config.Exchange, routingKey, true, subject, msg)
+}
+
+func (r *RabbitMQService) publishTo(ctx context.Context, exchange, routingKey string, mandatory bool, subject string, msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return r.publishEvent(ctx, exchange, routingKey, mandatory, envelope.Envelope{Subject: subject}, body)
+}
+
+// PublishOutboxMessage publishes an already serialised message from the
+// outbox. The outbox row ID is sent as the message ID so consumers can
+// recognise a message the relay published twice.
+func (r *RabbitMQService) PublishOutboxMessage(ctx context.Context, exchange, routingKey, messageID, subject string, body []byte) error {
+	return r.publishEvent(ctx, exchange, routingKey, true, envelope.Envelope{
+		ID:      messageID,
+		Subject: subject,
+	}, body)
+}
+
+// publishEvent publishes JSON data in a CloudEvents envelope
+func (r *RabbitMQService) publishEvent(ctx context.Context, exchange, routingKey string, mandatory bool, env envelope.Envelope, body []byte) error {
 	r.mu.RLock()
-	defer r.mu.RUnlock()
+	source := r.serviceName
+	r.mu.RUnlock()
+	env = broker.NewEnvelope(ctx, r.config, source, exchange, routingKey, env)
+
+	return r.publishBody(ctx, exchange, routingKey, mandatory, amqp.Publishing{
+		Headers:       amqp.Table(env.Headers()),
+		ContentType:   "application/json",
+		Body:          body,
+		DeliveryMode:  amqp.Persistent,
+		Timestamp:     env.Time,
+		MessageId:     env.ID,
+		CorrelationId: env.CorrelationID,
+		Type:          env.Type,
+		AppId:         env.Source,
+	})
+}
 
-	if r.channel == nil {
-		return nil, fmt.Errorf("RabbitMQ channel is nil")
+// publishBody publishes a message and waits until the broker confirms it or
+// ctx is done. Every message gets an ID so a basic.return can be matched to
+// its publish.
+func (r *RabbitMQService) publishBody(ctx context.Context, exchange, routingKey string, mandatory bool, publishing amqp.Publishing) error {
+	if publishing.MessageId == "" {
+		publishing.MessageId = ids.NewUUID()
 	}
 
-	msgs, err := r.channel.Consume(
-		r.config.Queue.Purchased, // queue
-		"",                       // consumer tag
-		false,                    // auto-ack
-		false,                    // exclusive
-		false,                    // no-local
-		false,                    // no-wait
-		nil,                      // args
-	)
+	r.mu.RLock()
+	channel, confirms, serviceName := r.channel, r.confirms, r.serviceName
+	r.mu.RUnlock()
+
+	destination := routingKey
+	if destination == "" {
+		destination = exchange
+	}
+
+	// Fail fast while reconnecting; callers that must not lose the message
+	// go through the outbox
+	if channel == nil {
+		metrics.RabbitMQMessages.WithLabelValues("publish", destination, "not_connected", serviceName).Inc()
+		return ErrNotConnected
+	}
+
+	start := time.Now()
+	r.publishMu.Lock()
+	tag, done := confirms.expect(publishing.MessageId)
+	if err := channel.Publish(
+		exchange,
+		routingKey,
+		mandatory,
+		false,
+		publishing,
+	); err != nil {
+		confirms.forget(tag)
+		r.publishMu.Unlock()
+
+		log.WithError(err).Error("Failed to publish message to RabbitMQ")
+		metrics.RabbitMQMessages.WithLabelValues("publish", destination, "error", serviceName).Inc()
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	confirms.sent()
+	r.publishMu.Unlock()
+
+	timeout := time.NewTimer(time.Duration(r.config.ConfirmTimeoutSeconds) * time.Second)
+	defer timeout.Stop()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-timeout.C:
+		confirms.forget(tag)
+		err = ErrConfirmTimeout
+	case <-ctx.Done():
+		confirms.forget(tag)
+		err = ctx.Err()
+	}
+
+	metrics.RabbitMQConfirmDuration.WithLabelValues(destination, serviceName).Observe(time.Since(start).Seconds())
+	status := "confirmed"
+	switch {
+	case errors.Is(err, ErrNacked):
+		status = "nacked"
+	case errors.Is(err, ErrUnroutable):
+		status = "unroutable"
+	case errors.Is(err, ErrConfirmTimeout):
+		status = "timeout"
+	case err != nil:
+		status = "error"
+	}
+	metrics.RabbitMQMessages.WithLabelValues("publish", destination, status, serviceName).Inc()
 
 	if err != nil {
-		log.WithError(err).Error("Failed to register consumer")
-		return nil, fmt.Errorf("failed to register consumer: %w", err)
+		log.WithError(err).WithFields(log.Fields{
+			"exchange":    exchange,
+			"routing_key": routingKey,
+			"message_id":  publishing.MessageId,
+		}).Error("RabbitMQ did not confirm message")
+		return fmt.Errorf("failed to publish message: %w", err)
 	}
 
-	log.WithField("queue", r.config.Queue.Purchased).Info("Consumer registered successfully")
-	return msgs, nil
+	return nil
 }
 
+func (r *RabbitMQService) ConsumeTicketPurchased() (<-chan broker.Delivery, error) {
+	return r.consume(r.config.Queue.Purchased)
+}
+
+// ConsumeEventLifecycle delivers event.cancelled and event.rescheduled messages
+func (r *RabbitMQService) ConsumeEventLifecycle() (<-chan broker.Delivery, error) {
+	return r.consume(r.config.Queue.EventLifecycle)
+}
+
+// ConsumeTicketNotifications delivers ticket.notification messages
+func (r *RabbitMQService) ConsumeTicketNotifications() (<-chan broker.Delivery, error) {
+	return r.consume(r.config.Queue.Notification)
+}
+
+// ConsumeTicketStatus delivers ticket status changes through a private,
+// auto-deleted queue, so every replica sees every update. Deliveries are
+// auto-acked: they only feed live streams and are not worth redelivering.
+// The queue is declared again on every reconnect.
+func (r *RabbitMQService) ConsumeTicketStatus() (<-chan broker.Delivery, error) {
+	return r.subscribe("ticket status", func(channel *amqp.Channel, tag string) (<-chan amqp.Delivery, error) {
+		queue, err := channel.QueueDeclare(
+			"",    // server-named
+			false, // durable
+			true,  // auto-delete
+			true,  // exclusive
+			false, // no-wait
+			nil,   // args
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to declare status queue: %w", err)
+		}
+
+		if err := channel.QueueBind(queue.Name, "", r.config.StatusExchange, false, nil); err != nil {
+			return nil, fmt.Errorf("failed to bind status queue: %w", err)
+		}
+
+		msgs, err := channel.Consume(
+			queue.Name, // queue
+			tag,        // consumer tag
+			true,       // auto-ack
+			true,       // exclusive
+			false,      // no-local
+			false,      // no-wait
+			nil,        // args
+		)
+		if err != nil {
+			log.WithError(err).Error("Failed to register status consumer")
+			return nil, fmt.Errorf("failed to register consumer: %w", err)
+		}
+
+		log.WithField("queue", queue.Name).Info("Status consumer registered successfully")
+		return msgs, nil
+	})
+}
+
+func (r *RabbitMQService) consume(queueName string) (<-chan broker.Delivery, error) {
+	return r.subscribe(queueName, func(channel *amqp.Channel, tag string) (<-chan amqp.Delivery, error) {
+		msgs, err := channel.Consume(
+			queueName, // queue
+			tag,       // consumer tag
+			false,     // auto-ack
+			false,     // exclusive
+			false,     // no-local
+			false,     // no-wait
+			nil,       // args
+		)
+
+		if err != nil {
+			log.WithError(err).Error("Failed to register consumer")
+			return nil, fmt.Errorf("failed to register consumer: %w", err)
+		}
+
+		log.WithField("queue", queueName).Info("Consumer registered successfully")
+		return msgs, nil
+	})
+}
+
+// HealthCheck fails while the connection or channel is down, including
+// while reconnecting
 func (r *RabbitMQService) HealthCheck() error {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -195,10 +500,18 @@ func (r *RabbitMQService) HealthCheck() error {
 	return nil
 }
 
+// Close stops the supervisor, closes the connection and ends all consumers
 func (r *RabbitMQService) Close() error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.isHealthy = false
+	close(r.done)
+
 	if r.channel != nil {
 		if err := r.channel.Close(); err != nil {
 			log.WithError(err).Warn("Failed to close RabbitMQ channel")
@@ -210,6 +523,8 @@ func (r *RabbitMQService) Close() error {
 			log.WithError(err).Warn("Failed to close RabbitMQ connection")
 		}
 	}
+	r.channel = nil
+	r.conn = nil
 
 	log.Info("Successfully closed RabbitMQ connection")
 	return nil
@@ -0,0 +1,101 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/streadway/amqp"
+)
+
+// RetryQueueName is the delay queue used for the given retry attempt (1-based)
+func RetryQueueName(queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, attempt)
+}
+
+// declareRetryQueues declares the dead-letter exchange, and per consumed
+// queue a dead-letter queue bound to it plus one delay queue per retry
+// attempt. A delay queue holds messages for its TTL and then dead-letters
+// them through the default exchange back onto the original queue. Failed
+// messages are moved explicitly by the consumer rather than through a
+// x-dead-letter-exchange argument on the work queues, since existing queues
+// can't be redeclared with new arguments.
+func declareRetryQueues(channel *amqp.Channel, cfg *configs.RabbitMQConfig) error {
+	if err := channel.ExchangeDeclare(
+		cfg.DeadLetterExchange,
+		"direct",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", cfg.DeadLetterExchange, err)
+	}
+
+	for _, queueName := range broker.ConsumedQueues(cfg) {
+		dlq := broker.DeadLetterQueueName(queueName)
+		if _, err := channel.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", dlq, err)
+		}
+		if err := channel.QueueBind(dlq, queueName, cfg.DeadLetterExchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue %s: %w", dlq, err)
+		}
+
+		for attempt := 1; attempt <= cfg.MaxRetries; attempt++ {
+			retryQueue := RetryQueueName(queueName, attempt)
+			if _, err := channel.QueueDeclare(
+				retryQueue,
+				true,
+				false,
+				false,
+				false,
+				amqp.Table{
+					"x-message-ttl":             int64(broker.RetryDelay(cfg, attempt) / time.Millisecond),
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": queueName,
+				},
+			); err != nil {
+				return fmt.Errorf("failed to declare queue %s: %w", retryQueue, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Retry publishes a copy of a failed delivery from queue to the delay queue
+// of its next attempt. The caller acks the original once the copy is
+// published. Returns false without publishing when the retries are used up.
+func (r *RabbitMQService) Retry(queue string, msg broker.Delivery, cause error) (bool, error) {
+	attempt := broker.RetryCount(msg) + 1
+	if attempt > r.config.MaxRetries {
+		return false, nil
+	}
+
+	// The delay queues are reached through the default exchange
+	return true, r.publishBody(context.Background(), "", RetryQueueName(queue, attempt), true, republishing(msg, broker.RetryHeaders(msg, attempt, cause)))
+}
+
+// DeadLetter moves a delivery from queue to its dead-letter queue. The caller
+// acks the original once the copy is published.
+func (r *RabbitMQService) DeadLetter(queue string, msg broker.Delivery, reason string) error {
+	return r.publishBody(context.Background(), r.config.DeadLetterExchange, queue, true, republishing(msg, broker.DeadLetterHeaders(msg, queue, reason)))
+}
+
+// republishing copies a delivery into a new persistent publishing
+func republishing(msg broker.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		CorrelationId: msg.CorrelationID,
+		MessageId:     msg.MessageID,
+		Timestamp:     msg.Timestamp,
+		Type:          msg.Type,
+		AppId:         msg.AppID,
+		DeliveryMode:  amqp.Persistent,
+		Body:          msg.Body,
+	}
+}
@@ -0,0 +1,22 @@
+package rabbitmq
+
+import (
+	"testing"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryQueueNames(t *testing.T) {
+	assert.Equal(t, "ticket.purchased.retry.2", RetryQueueName("ticket.purchased", 2))
+}
+
+func TestRetryGivesUpAfterMaxRetries(t *testing.T) {
+	r := &RabbitMQService{config: &configs.RabbitMQConfig{MaxRetries: 2}}
+	msg := broker.Delivery{Headers: map[string]interface{}{broker.HeaderRetryCount: int32(2)}}
+
+	retried, err := r.Retry("ticket.purchased", msg, assert.AnError)
+	assert.NoError(t, err)
+	assert.False(t, retried)
+}
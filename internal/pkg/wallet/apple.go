@@ -0,0 +1,282 @@
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"go.mozilla.org/pkcs7"
+)
+
+// ContentTypePKPass is the MIME type of Apple Wallet passes
+const ContentTypePKPass = "application/vnd.apple.pkpass"
+
+// brandColor is used for the pass background and the generated icon
+var brandColor = color.RGBA{R: 0x1f, G: 0x29, B: 0x37, A: 0xff}
+
+// AppleSigner builds .pkpass bundles signed with a pass type certificate
+type AppleSigner struct {
+	cert               *x509.Certificate
+	key                crypto.PrivateKey
+	wwdr               *x509.Certificate
+	passTypeIdentifier string
+	teamIdentifier     string
+	organizationName   string
+}
+
+// NewAppleSigner loads the certificates and key configured for Apple Wallet
+func NewAppleSigner(cfg configs.AppleWalletConfig) (*AppleSigner, error) {
+	if cfg.PassTypeIdentifier == "" || cfg.TeamIdentifier == "" || cfg.CertificatePath == "" || cfg.KeyPath == "" {
+		return nil, ErrNotConfigured
+	}
+
+	var files [3][]byte
+	for i, path := range []string{cfg.CertificatePath, cfg.KeyPath, cfg.WWDRCertificatePath} {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		files[i] = raw
+	}
+
+	return NewAppleSignerFromPEM(cfg, files[0], files[1], files[2])
+}
+
+// NewAppleSignerFromPEM is like NewAppleSigner with the PEM data in memory
+func NewAppleSignerFromPEM(cfg configs.AppleWalletConfig, certPEM, keyPEM, wwdrPEM []byte) (*AppleSigner, error) {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pass certificate: %w", err)
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pass key: %w", err)
+	}
+	wwdr, err := parseCertificate(wwdrPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse WWDR certificate: %w", err)
+	}
+
+	return &AppleSigner{
+		cert:               cert,
+		key:                key,
+		wwdr:               wwdr,
+		passTypeIdentifier: cfg.PassTypeIdentifier,
+		teamIdentifier:     cfg.TeamIdentifier,
+		organizationName:   cfg.OrganizationName,
+	}, nil
+}
+
+type passField struct {
+	Key       string `json:"key"`
+	Label     string `json:"label,omitempty"`
+	Value     string `json:"value"`
+	DateStyle string `json:"dateStyle,omitempty"`
+	TimeStyle string `json:"timeStyle,omitempty"`
+}
+
+type passBarcode struct {
+	Format          string `json:"format"`
+	Message         string `json:"message"`
+	MessageEncoding string `json:"messageEncoding"`
+	AltText         string `json:"altText,omitempty"`
+}
+
+type passStructure struct {
+	PrimaryFields   []passField `json:"primaryFields"`
+	SecondaryFields []passField `json:"secondaryFields,omitempty"`
+	AuxiliaryFields []passField `json:"auxiliaryFields,omitempty"`
+	BackFields      []passField `json:"backFields,omitempty"`
+}
+
+type passJSON struct {
+	FormatVersion      int           `json:"formatVersion"`
+	PassTypeIdentifier string        `json:"passTypeIdentifier"`
+	SerialNumber       string        `json:"serialNumber"`
+	TeamIdentifier     string        `json:"teamIdentifier"`
+	OrganizationName   string        `json:"organizationName"`
+	Description        string        `json:"description"`
+	RelevantDate       string        `json:"relevantDate,omitempty"`
+	ExpirationDate     string        `json:"expirationDate,omitempty"`
+	BackgroundColor    string        `json:"backgroundColor"`
+	ForegroundColor    string        `json:"foregroundColor"`
+	LabelColor         string        `json:"labelColor"`
+	Barcodes           []passBarcode `json:"barcodes"`
+	EventTicket        passStructure `json:"eventTicket"`
+}
+
+// PKPass builds the signed .pkpass bundle for a ticket: a zip of pass.json,
+// the icons, manifest.json with the SHA-1 of every file and a detached
+// PKCS#7 signature of the manifest.
+func (s *AppleSigner) PKPass(t Ticket) ([]byte, error) {
+	passData, err := json.Marshal(s.passJSON(t))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal pass.json: %w", err)
+	}
+
+	// The zip order follows this list so bundles are reproducible
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"pass.json", passData},
+		{"icon.png", iconPNG(29)},
+		{"icon@2x.png", iconPNG(58)},
+		{"icon@3x.png", iconPNG(87)},
+		{"logo.png", iconPNG(50)},
+		{"logo@2x.png", iconPNG(100)},
+		{"logo@3x.png", iconPNG(150)},
+	}
+
+	manifest := make(map[string]string, len(files))
+	for _, f := range files {
+		sum := sha1.Sum(f.data)
+		manifest[f.name] = hex.EncodeToString(sum[:])
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest.json: %w", err)
+	}
+
+	signature, err := s.sign(manifestData)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, data []byte) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	for _, f := range files {
+		if err := add(f.name, f.data); err != nil {
+			return nil, fmt.Errorf("failed to add %s: %w", f.name, err)
+		}
+	}
+	if err := add("manifest.json", manifestData); err != nil {
+		return nil, fmt.Errorf("failed to add manifest.json: %w", err)
+	}
+	if err := add("signature", signature); err != nil {
+		return nil, fmt.Errorf("failed to add signature: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish pkpass: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sign creates the detached PKCS#7 signature of the manifest, including the
+// WWDR intermediate so Wallet can build the chain
+func (s *AppleSigner) sign(manifest []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signature: %w", err)
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSignerChain(s.cert, s.key, []*x509.Certificate{s.wwdr}, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("failed to sign manifest: %w", err)
+	}
+	sd.Detach()
+
+	signature, err := sd.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("failed to finish signature: %w", err)
+	}
+	return signature, nil
+}
+
+func (s *AppleSigner) passJSON(t Ticket) passJSON {
+	eventName := t.EventName
+	if eventName == "" {
+		eventName = t.EventID
+	}
+
+	pass := passJSON{
+		FormatVersion:      1,
+		PassTypeIdentifier: s.passTypeIdentifier,
+		SerialNumber:       t.ID,
+		TeamIdentifier:     s.teamIdentifier,
+		OrganizationName:   s.organizationName,
+		Description:        "Ticket for " + eventName,
+		BackgroundColor:    fmt.Sprintf("rgb(%d, %d, %d)", brandColor.R, brandColor.G, brandColor.B),
+		ForegroundColor:    "rgb(255, 255, 255)",
+		LabelColor:         "rgb(209, 213, 219)",
+		Barcodes: []passBarcode{{
+			Format:          "PKBarcodeFormatQR",
+			Message:         t.ID,
+			MessageEncoding: "iso-8859-1",
+			AltText:         shortID(t.ID),
+		}},
+		EventTicket: passStructure{
+			PrimaryFields: []passField{{Key: "event", Label: "EVENT", Value: eventName}},
+			AuxiliaryFields: []passField{
+				{Key: "quantity", Label: "TICKETS", Value: strconv.Itoa(t.Quantity)},
+			},
+			BackFields: []passField{
+				{Key: "ticket", Label: "Ticket ID", Value: t.ID},
+			},
+		},
+	}
+
+	if t.StartsAt != nil {
+		pass.RelevantDate = t.StartsAt.UTC().Format(time.RFC3339)
+		pass.EventTicket.SecondaryFields = append(pass.EventTicket.SecondaryFields, passField{
+			Key:       "date",
+			Label:     "DATE",
+			Value:     t.StartsAt.UTC().Format(time.RFC3339),
+			DateStyle: "PKDateStyleMedium",
+			TimeStyle: "PKDateStyleShort",
+		})
+	}
+	if t.EndsAt != nil {
+		pass.ExpirationDate = t.EndsAt.UTC().Format(time.RFC3339)
+	}
+	if t.Location != "" {
+		pass.EventTicket.SecondaryFields = append(pass.EventTicket.SecondaryFields, passField{Key: "location", Label: "LOCATION", Value: t.Location})
+	}
+	if t.HolderName != "" {
+		pass.EventTicket.AuxiliaryFields = append(pass.EventTicket.AuxiliaryFields, passField{Key: "holder", Label: "HOLDER", Value: t.HolderName})
+	}
+
+	return pass
+}
+
+// iconPNG renders a square icon in the brand colour
+func iconPNG(size int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, brandColor)
+		}
+	}
+
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// shortID is the human-readable fallback printed under the barcode
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
@@ -0,0 +1,163 @@
+package wallet
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/oskargbc/dws-ticket-service/configs"
+)
+
+// googleSaveURL is the prefix of Google Wallet "Add to Google Wallet" links
+const googleSaveURL = "https://pay.google.com/gp/v/save/"
+
+// invalidIDChars are not allowed in Google Wallet class and object IDs
+var invalidIDChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// GoogleSigner creates signed save links for Google Wallet event tickets.
+// The JWT carries both the event class and the ticket object, so nothing has
+// to be created through the Wallet API beforehand.
+type GoogleSigner struct {
+	issuerID            string
+	serviceAccountEmail string
+	key                 *rsa.PrivateKey
+	origins             []string
+}
+
+// NewGoogleSigner loads the service account key configured for Google Wallet
+func NewGoogleSigner(cfg configs.GoogleWalletConfig) (*GoogleSigner, error) {
+	if cfg.IssuerID == "" || cfg.ServiceAccountEmail == "" || cfg.PrivateKeyPath == "" {
+		return nil, ErrNotConfigured
+	}
+
+	keyPEM, err := os.ReadFile(cfg.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", cfg.PrivateKeyPath, err)
+	}
+
+	return NewGoogleSignerFromPEM(cfg, keyPEM)
+}
+
+// NewGoogleSignerFromPEM is like NewGoogleSigner with the key in memory
+func NewGoogleSignerFromPEM(cfg configs.GoogleWalletConfig, keyPEM []byte) (*GoogleSigner, error) {
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("service account key must be an RSA key")
+	}
+
+	return &GoogleSigner{
+		issuerID:            cfg.IssuerID,
+		serviceAccountEmail: cfg.ServiceAccountEmail,
+		key:                 rsaKey,
+		origins:             cfg.Origins,
+	}, nil
+}
+
+type localizedString struct {
+	DefaultValue struct {
+		Language string `json:"language"`
+		Value    string `json:"value"`
+	} `json:"defaultValue"`
+}
+
+func localized(value string) *localizedString {
+	l := &localizedString{}
+	l.DefaultValue.Language = "en-US"
+	l.DefaultValue.Value = value
+	return l
+}
+
+type googleEventTicketClass struct {
+	ID           string           `json:"id"`
+	IssuerName   string           `json:"issuerName"`
+	EventName    *localizedString `json:"eventName"`
+	ReviewStatus string           `json:"reviewStatus"`
+	Venue        *struct {
+		Name *localizedString `json:"name"`
+	} `json:"venue,omitempty"`
+	DateTime *googleDateTime `json:"dateTime,omitempty"`
+}
+
+type googleDateTime struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+type googleEventTicketObject struct {
+	ID               string `json:"id"`
+	ClassID          string `json:"classId"`
+	State            string `json:"state"`
+	TicketNumber     string `json:"ticketNumber"`
+	TicketHolderName string `json:"ticketHolderName,omitempty"`
+	Barcode          struct {
+		Type          string `json:"type"`
+		Value         string `json:"value"`
+		AlternateText string `json:"alternateText,omitempty"`
+	} `json:"barcode"`
+}
+
+// SaveLink returns the "Add to Google Wallet" URL for a ticket
+func (s *GoogleSigner) SaveLink(t Ticket, issuerName string) (string, error) {
+	eventName := t.EventName
+	if eventName == "" {
+		eventName = t.EventID
+	}
+
+	class := googleEventTicketClass{
+		ID:           s.issuerID + ".event_" + invalidIDChars.ReplaceAllString(t.EventID, "_"),
+		IssuerName:   issuerName,
+		EventName:    localized(eventName),
+		ReviewStatus: "UNDER_REVIEW",
+	}
+	if t.Location != "" {
+		class.Venue = &struct {
+			Name *localizedString `json:"name"`
+		}{Name: localized(t.Location)}
+	}
+	if t.StartsAt != nil {
+		class.DateTime = &googleDateTime{Start: t.StartsAt.UTC().Format(time.RFC3339)}
+		if t.EndsAt != nil {
+			class.DateTime.End = t.EndsAt.UTC().Format(time.RFC3339)
+		}
+	}
+
+	object := googleEventTicketObject{
+		ID:               s.issuerID + ".ticket_" + invalidIDChars.ReplaceAllString(t.ID, "_"),
+		ClassID:          class.ID,
+		State:            "ACTIVE",
+		TicketNumber:     t.ID,
+		TicketHolderName: t.HolderName,
+	}
+	object.Barcode.Type = "QR_CODE"
+	object.Barcode.Value = t.ID
+	object.Barcode.AlternateText = shortID(t.ID)
+
+	claims := jwt.MapClaims{
+		"iss":     s.serviceAccountEmail,
+		"aud":     "google",
+		"typ":     "savetowallet",
+		"iat":     time.Now().Unix(),
+		"origins": s.origins,
+		"payload": map[string]interface{}{
+			"eventTicketClasses": []googleEventTicketClass{class},
+			"eventTicketObjects": []googleEventTicketObject{object},
+		},
+	}
+	if s.origins == nil {
+		claims["origins"] = []string{}
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign save link: %w", err)
+	}
+
+	return googleSaveURL + signed, nil
+}
@@ -0,0 +1,59 @@
+package wallet
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotConfigured is returned when the credentials for a wallet are missing
+var ErrNotConfigured = errors.New("wallet pass signing is not configured")
+
+// Ticket is what a wallet pass shows. The barcode encodes the ticket ID.
+type Ticket struct {
+	ID         string
+	EventID    string
+	EventName  string
+	Location   string
+	HolderName string
+	Quantity   int
+	StartsAt   *time.Time
+	EndsAt     *time.Time
+}
+
+func decodePEM(raw []byte) (*pem.Block, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return block, nil
+}
+
+func parseCertificate(raw []byte) (*x509.Certificate, error) {
+	block, err := decodePEM(raw)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parsePrivateKey accepts PKCS#1, PKCS#8 and EC private keys
+func parsePrivateKey(raw []byte) (crypto.PrivateKey, error) {
+	block, err := decodePEM(raw)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key format %q", block.Type)
+}
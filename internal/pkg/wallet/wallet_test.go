@@ -0,0 +1,161 @@
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mozilla.org/pkcs7"
+)
+
+func testTicket() Ticket {
+	startsAt := time.Date(2025, 11, 20, 19, 0, 0, 0, time.UTC)
+	return Ticket{
+		ID:         "1b4e28ba-2fa1-11d2-883f-0016d3cca427",
+		EventID:    "event-123",
+		EventName:  "Tech Conference",
+		Location:   "Luleå",
+		HolderName: "Ada Lovelace",
+		Quantity:   2,
+		StartsAt:   &startsAt,
+	}
+}
+
+// newCertificate issues a certificate signed by parent, or a self-signed CA
+// when parent is nil
+func newCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func keyPEM(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func TestApplePKPass(t *testing.T) {
+	wwdr, wwdrKey, wwdrPEM := newCertificate(t, "Test WWDR", nil, nil)
+	_, passKey, passPEM := newCertificate(t, "Pass Type ID: pass.test", wwdr, wwdrKey)
+
+	signer, err := NewAppleSignerFromPEM(configs.AppleWalletConfig{
+		PassTypeIdentifier: "pass.test",
+		TeamIdentifier:     "TEAM123",
+		OrganizationName:   "DWS Events",
+	}, passPEM, keyPEM(passKey), wwdrPEM)
+	require.NoError(t, err)
+
+	bundle, err := signer.PKPass(testTicket())
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		files[f.Name], err = io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+	}
+	require.Contains(t, files, "pass.json")
+	require.Contains(t, files, "icon.png")
+	require.Contains(t, files, "manifest.json")
+	require.Contains(t, files, "signature")
+
+	var pass passJSON
+	require.NoError(t, json.Unmarshal(files["pass.json"], &pass))
+	assert.Equal(t, "pass.test", pass.PassTypeIdentifier)
+	assert.Equal(t, testTicket().ID, pass.SerialNumber)
+	assert.Equal(t, testTicket().ID, pass.Barcodes[0].Message)
+	assert.Equal(t, "2025-11-20T19:00:00Z", pass.RelevantDate)
+
+	var manifest map[string]string
+	require.NoError(t, json.Unmarshal(files["manifest.json"], &manifest))
+	for name, hash := range manifest {
+		sum := sha1.Sum(files[name])
+		assert.Equal(t, hex.EncodeToString(sum[:]), hash, name)
+	}
+	assert.Len(t, manifest, len(files)-2)
+
+	p7, err := pkcs7.Parse(files["signature"])
+	require.NoError(t, err)
+	p7.Content = files["manifest.json"]
+	roots := x509.NewCertPool()
+	roots.AddCert(wwdr)
+	assert.NoError(t, p7.VerifyWithChain(roots))
+}
+
+func TestGoogleSaveLink(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	signer, err := NewGoogleSignerFromPEM(configs.GoogleWalletConfig{
+		IssuerID:            "3388000000012345",
+		ServiceAccountEmail: "wallet@dws.iam.gserviceaccount.com",
+		Origins:             []string{"https://frontend.example.com"},
+	}, keyPEM(key))
+	require.NoError(t, err)
+
+	link, err := signer.SaveLink(testTicket(), "DWS Events")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(link, googleSaveURL))
+
+	token, err := jwt.Parse(strings.TrimPrefix(link, googleSaveURL), func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	require.NoError(t, err)
+
+	claims := token.Claims.(jwt.MapClaims)
+	assert.Equal(t, "google", claims["aud"])
+	assert.Equal(t, "savetowallet", claims["typ"])
+
+	payload := claims["payload"].(map[string]interface{})
+	object := payload["eventTicketObjects"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "3388000000012345.ticket_1b4e28ba-2fa1-11d2-883f-0016d3cca427", object["id"])
+	assert.Equal(t, "3388000000012345.event_event-123", object["classId"])
+}
+
+func TestSignersRequireConfiguration(t *testing.T) {
+	_, err := NewAppleSigner(configs.AppleWalletConfig{})
+	assert.ErrorIs(t, err, ErrNotConfigured)
+
+	_, err = NewGoogleSigner(configs.GoogleWalletConfig{})
+	assert.ErrorIs(t, err, ErrNotConfigured)
+}
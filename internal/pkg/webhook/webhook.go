@@ -0,0 +1,145 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ticket lifecycle event types partners can subscribe to
+const (
+	EventTicketPurchased = "ticket.purchased"
+	EventTicketConfirmed = "ticket.confirmed"
+	EventTicketCancelled = "ticket.cancelled"
+	EventTicketRefunded  = "ticket.refunded"
+)
+
+// EventTypes lists every event type a subscription may select
+var EventTypes = []string{
+	EventTicketPurchased,
+	EventTicketConfirmed,
+	EventTicketCancelled,
+	EventTicketRefunded,
+}
+
+// Headers set on every delivery
+const (
+	HeaderSignature = "X-DWS-Signature"
+	HeaderEvent     = "X-DWS-Event"
+	HeaderDelivery  = "X-DWS-Delivery"
+)
+
+// ValidEventType reports whether t is a known event type
+func ValidEventType(t string) bool {
+	for _, known := range EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret returns a random signing secret for a subscription
+func NewSecret() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b[:]), nil
+}
+
+// Sign computes the signature header value for a payload. The timestamp is
+// part of the signed content so receivers can reject replayed deliveries.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Verify checks a signature header against a payload, rejecting signatures
+// whose timestamp is more than tolerance away from now in either direction
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) bool {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return false
+	}
+	signedAt := time.Unix(unix, 0)
+	skew := now.Sub(signedAt)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > tolerance {
+		return false
+	}
+
+	expected := Sign(secret, signedAt, body)
+	return hmac.Equal([]byte(expected), []byte(fmt.Sprintf("t=%s,v1=%s", ts, sig)))
+}
+
+// Backoff returns the delay before the given retry attempt (1-based),
+// doubling from base and capped at max
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	return delay
+}
+
+// Deliver POSTs a signed payload. It returns the response status code; any
+// non-2xx status is reported as an error.
+func Deliver(ctx context.Context, client *http.Client, url, secret, eventType, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dws-ticket-service-webhooks/1.0")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderSignature, Sign(secret, time.Now(), body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
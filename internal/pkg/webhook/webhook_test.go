@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"ticket.confirmed"}`)
+	now := time.Now()
+	sig := Sign("whsec_test", now, body)
+
+	assert.True(t, Verify("whsec_test", sig, body, 5*time.Minute, now))
+	assert.False(t, Verify("whsec_other", sig, body, 5*time.Minute, now))
+	assert.False(t, Verify("whsec_test", sig, []byte(`{}`), 5*time.Minute, now))
+	assert.False(t, Verify("whsec_test", sig, body, 5*time.Minute, now.Add(10*time.Minute)))
+
+	// Timestamps in the future don't extend the replay window
+	future := Sign("whsec_test", now.Add(time.Hour), body)
+	assert.False(t, Verify("whsec_test", future, body, 5*time.Minute, now))
+	assert.True(t, Verify("whsec_test", Sign("whsec_test", now.Add(time.Minute), body), body, 5*time.Minute, now))
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, Backoff(1, 30*time.Second, time.Hour))
+	assert.Equal(t, 120*time.Second, Backoff(3, 30*time.Second, time.Hour))
+	assert.Equal(t, time.Hour, Backoff(20, 30*time.Second, time.Hour))
+}
+
+func TestDeliver(t *testing.T) {
+	body := []byte(`{"id":"delivery-1"}`)
+	status := http.StatusOK
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ := io.ReadAll(r.Body)
+		assert.Equal(t, body, received)
+		assert.Equal(t, EventTicketConfirmed, r.Header.Get(HeaderEvent))
+		assert.Equal(t, "delivery-1", r.Header.Get(HeaderDelivery))
+		assert.True(t, Verify("whsec_test", r.Header.Get(HeaderSignature), received, time.Minute, time.Now()))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	code, err := Deliver(context.Background(), srv.Client(), srv.URL, "whsec_test", EventTicketConfirmed, "delivery-1", body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	status = http.StatusBadGateway
+	code, err = Deliver(context.Background(), srv.Client(), srv.URL, "whsec_test", EventTicketConfirmed, "delivery-1", body)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadGateway, code)
+}
@@ -0,0 +1,61 @@
+package workerpool
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// Pool runs tasks on a fixed number of workers. Tasks are sharded by key:
+// tasks with the same key always run on the same worker in the order they
+// were submitted, tasks with different keys run concurrently.
+type Pool[T any] struct {
+	shards []chan T
+	key    func(T) string
+	wg     sync.WaitGroup
+}
+
+// New starts a pool of workers calling handle. Each worker queues up to
+// buffer tasks before Submit blocks.
+func New[T any](workers, buffer int, key func(T) string, handle func(T)) *Pool[T] {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &Pool[T]{
+		shards: make([]chan T, workers),
+		key:    key,
+	}
+	for i := range p.shards {
+		tasks := make(chan T, buffer)
+		p.shards[i] = tasks
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range tasks {
+				handle(task)
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues a task on the worker owning its key
+func (p *Pool[T]) Submit(task T) {
+	p.shards[Shard(p.key(task), len(p.shards))] <- task
+}
+
+// Close stops accepting tasks and waits until the queued ones are done. No
+// task may be submitted after Close.
+func (p *Pool[T]) Close() {
+	for _, tasks := range p.shards {
+		close(tasks)
+	}
+	p.wg.Wait()
+}
+
+// Shard maps a key to one of n shards
+func Shard(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
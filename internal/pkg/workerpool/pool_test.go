@@ -0,0 +1,75 @@
+package workerpool
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type task struct {
+	key string
+	seq int
+}
+
+func TestPoolKeepsOrderPerKey(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+
+	pool := New(4, 8, func(t task) string { return t.key }, func(t task) {
+		mu.Lock()
+		seen[t.key] = append(seen[t.key], t.seq)
+		mu.Unlock()
+	})
+
+	for seq := 0; seq < 50; seq++ {
+		for k := 0; k < 10; k++ {
+			pool.Submit(task{key: fmt.Sprintf("ticket-%d", k), seq: seq})
+		}
+	}
+	pool.Close()
+
+	assert.Len(t, seen, 10)
+	for key, seqs := range seen {
+		assert.Len(t, seqs, 50, key)
+		for i, seq := range seqs {
+			assert.Equal(t, i, seq, key)
+		}
+	}
+}
+
+func TestPoolRunsKeysConcurrently(t *testing.T) {
+	var running, peak atomic.Int32
+	pool := New(4, 1, func(t task) string { return t.key }, func(task) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+	})
+
+	// Pick keys that land on different shards
+	used := make(map[int]bool)
+	for k := 0; len(used) < 4; k++ {
+		key := fmt.Sprintf("ticket-%d", k)
+		if shard := Shard(key, 4); !used[shard] {
+			used[shard] = true
+			pool.Submit(task{key: key})
+		}
+	}
+	pool.Close()
+
+	assert.Equal(t, int32(4), peak.Load())
+}
+
+func TestShardIsStable(t *testing.T) {
+	assert.Equal(t, Shard("ticket-1", 8), Shard("ticket-1", 8))
+	assert.Equal(t, 0, Shard("anything", 1))
+}
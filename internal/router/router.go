@@ -4,17 +4,24 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/controllers/calendar"
+	"github.com/oskargbc/dws-ticket-service/internal/controllers/dashboard"
+	"github.com/oskargbc/dws-ticket-service/internal/controllers/deadletters"
 	"github.com/oskargbc/dws-ticket-service/internal/controllers/health"
 	"github.com/oskargbc/dws-ticket-service/internal/controllers/tickets"
+	"github.com/oskargbc/dws-ticket-service/internal/controllers/wallet"
+	"github.com/oskargbc/dws-ticket-service/internal/controllers/webhooks"
 	"github.com/oskargbc/dws-ticket-service/internal/middlewares"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/eventclient"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/live"
 	"github.com/oskargbc/dws-ticket-service/internal/pkg/metrics"
-	"github.com/oskargbc/dws-ticket-service/internal/pkg/rabbitmq"
 	"github.com/oskargbc/dws-ticket-service/internal/services"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
-func SetupRouter(cfg *configs.Config, dbService *services.DatabaseService, rmqService *rabbitmq.RabbitMQService) *gin.Engine {
+func SetupRouter(cfg *configs.Config, dbService *services.DatabaseService, rmqService broker.Broker, outboxService *services.OutboxService) *gin.Engine {
 	if cfg.Server.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -38,7 +45,21 @@ func SetupRouter(cfg *configs.Config, dbService *services.DatabaseService, rmqSe
 
 	// Initialize controllers
 	healthController := health.NewHealthController(dbService, rmqService)
-	ticketsController := tickets.NewTicketsController(dbService, rmqService)
+	invoiceService := services.NewInvoiceService(cfg, dbService)
+	eventClient := eventclient.NewClient(cfg.EventService)
+	webhookService := services.NewWebhookService(cfg, dbService)
+	statusHub := live.NewHub()
+	if statusMsgs, err := rmqService.ConsumeTicketStatus(); err != nil {
+		log.WithError(err).Warn("Failed to subscribe to ticket status updates, live streams will only send the current status")
+	} else {
+		go statusHub.Consume(statusMsgs)
+	}
+	ticketsController := tickets.NewTicketsController(dbService, rmqService, invoiceService, eventClient, webhookService, outboxService, statusHub)
+	webhooksController := webhooks.NewWebhooksController(dbService, webhookService)
+	dashboardController := dashboard.NewDashboardController(dbService, eventClient, statusHub, cfg.CORS.AllowedOrigins)
+	walletController := wallet.NewWalletController(dbService, services.NewWalletService(cfg))
+	calendarController := calendar.NewCalendarController(dbService, cfg.Server.PublicURL)
+	deadLettersController := deadletters.NewDeadLettersController(rmqService)
 
 	// API v1 routes
 	v1 := router.Group("/api/v1")
@@ -59,24 +80,66 @@ func SetupRouter(cfg *configs.Config, dbService *services.DatabaseService, rmqSe
 			ticketsGroup.GET("/my-tickets", ticketsController.GetMyTickets)
 			ticketsGroup.GET("/:id", ticketsController.GetTicketByID)
 			ticketsGroup.DELETE("/:id", ticketsController.CancelTicket)
+			ticketsGroup.POST("/:id/retry-payment", ticketsController.RetryPayment)
+			ticketsGroup.GET("/:id/invoice", ticketsController.GetInvoice)
+			ticketsGroup.GET("/:id/wallet/apple", walletController.GetApplePass)
+			ticketsGroup.GET("/:id/wallet/google", walletController.GetGoogleSaveLink)
+			ticketsGroup.GET("/:id/calendar.ics", calendarController.GetTicketCalendar)
 			// Admin/Organiser endpoint to get all tickets
 			ticketsGroup.GET("", middlewares.RequireRole("Organiser"), ticketsController.GetAllTickets)
 		}
+		// Server-Sent Events, also authenticated by query parameter
+		v1.GET("/tickets/:id/events", middlewares.KeycloakStreamAuthMiddleware(cfg), ticketsController.StreamTicketEvents)
+
+		// Webhook subscriptions (organisers only)
+		webhooksGroup := v1.Group("/webhooks")
+		webhooksGroup.Use(middlewares.KeycloakAuthMiddleware(cfg), middlewares.RequireRole("Organiser"))
+		{
+			webhooksGroup.POST("", webhooksController.CreateWebhook)
+			webhooksGroup.GET("", webhooksController.ListWebhooks)
+			webhooksGroup.DELETE("/:id", webhooksController.DeleteWebhook)
+			webhooksGroup.GET("/:id/deliveries", webhooksController.ListDeliveries)
+			webhooksGroup.POST("/deliveries/:id/redeliver", webhooksController.RedeliverWebhook)
+		}
+
+		// Calendar feed management (auth required)
+		calendarGroup := v1.Group("/calendar")
+		{
+			calendarGroup.POST("/feed", middlewares.KeycloakAuthMiddleware(cfg), calendarController.CreateFeed)
+			calendarGroup.DELETE("/feed", middlewares.KeycloakAuthMiddleware(cfg), calendarController.RevokeFeed)
+			// Subscribable feed (no auth required, the URL holds a secret token)
+			calendarGroup.GET("/feeds/:token/tickets.ics", calendarController.GetFeed)
+		}
+
+		// Dead-letter queue inspection and replay (admins only)
+		adminGroup := v1.Group("/admin")
+		adminGroup.Use(middlewares.KeycloakAuthMiddleware(cfg), middlewares.RequireRole("Admin"))
+		{
+			adminGroup.GET("/dead-letters/:queue", deadLettersController.ListDeadLetters)
+			adminGroup.GET("/dead-letters/:queue/:messageId", deadLettersController.GetDeadLetter)
+			adminGroup.POST("/dead-letters/:queue/replay", deadLettersController.ReplayDeadLetters)
+			adminGroup.POST("/dead-letters/:queue/purge", deadLettersController.PurgeDeadLetters)
+		}
 
 		// Public stats endpoint (no auth required)
 		v1.GET("/event-stats", ticketsController.GetEventStats)
+		// Live sales dashboard over WebSocket (organisers only)
+		v1.GET("/event-stats/live", middlewares.KeycloakStreamAuthMiddleware(cfg), middlewares.RequireRole("Organiser"), dashboardController.LiveEventStats)
 	}
 
 	return router
 }
 
+// requestLogger logs the route template rather than the request path, which
+// can carry secrets such as calendar feed tokens. Unmatched requests have an
+// empty path.
 func requestLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
 
 		log.WithFields(log.Fields{
 			"method":     c.Request.Method,
-			"path":       c.Request.URL.Path,
+			"path":       c.FullPath(),
 			"status":     c.Writer.Status(),
 			"ip":         c.ClientIP(),
 			"user_agent": c.Request.UserAgent(),
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/rabbitmq"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewBroker connects to the message broker selected by rabbitmq.driver:
+// "amqp" (default) for RabbitMQ or "memory" for an in-process broker whose
+// messages never leave the process
+func NewBroker(cfg *configs.Config) (broker.Broker, error) {
+	switch cfg.RabbitMQ.Driver {
+	case "", "amqp":
+		rmqService, err := rabbitmq.GetRabbitMQServiceInstance(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return rmqService, nil
+	case "memory":
+		log.Warn("Using the in-memory broker, messages are not shared with other processes")
+		return broker.NewMemory(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown broker driver: %s", cfg.RabbitMQ.Driver)
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/ids"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/invoice"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultOrganizerID is used for tickets purchased without an organiser
+const defaultOrganizerID = "default"
+
+// issueInvoiceSQL allocates the next sequence number for an organiser and
+// inserts the invoice in a single statement. The counter row stays locked
+// until the statement commits, and a failed insert rolls the increment back,
+// so numbers are gap-free per organiser.
+const issueInvoiceSQL = `
+WITH counter AS (
+	INSERT INTO invoice_counters ("organizerId", "lastSequence", "updatedAt")
+	VALUES ($1, 1, NOW())
+	ON CONFLICT ("organizerId") DO UPDATE
+	SET "lastSequence" = invoice_counters."lastSequence" + 1, "updatedAt" = NOW()
+	RETURNING "lastSequence"
+)
+INSERT INTO invoices ("id", "ticketId", "organizerId", "userId", "sequence", "number",
+	"currency", "netCents", "taxCents", "grossCents", "data", "updatedAt")
+SELECT $2, $3, $1, $4, counter."lastSequence", $5 || '-' || lpad(counter."lastSequence"::text, 6, '0'),
+	$6, $7, $8, $9, $10, NOW()
+FROM counter`
+
+type InvoiceService struct {
+	dbService *DatabaseService
+	config    *configs.InvoiceConfig
+}
+
+func NewInvoiceService(cfg *configs.Config, dbService *DatabaseService) *InvoiceService {
+	return &InvoiceService{
+		dbService: dbService,
+		config:    &cfg.Invoice,
+	}
+}
+
+// IssueForTicket creates the invoice for a confirmed ticket and stores its
+// PDF. It is idempotent: an existing invoice for the ticket is returned as is.
+func (s *InvoiceService) IssueForTicket(ctx context.Context, ticket *db.TicketModel) (*db.InvoiceModel, error) {
+	existing, err := s.dbService.Client.Invoice.FindUnique(
+		db.Invoice.TicketID.Equals(ticket.ID),
+	).Exec(ctx)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("failed to look up invoice: %w", err)
+	}
+
+	organizerID, ok := ticket.OrganizerID()
+	if !ok || organizerID == "" {
+		organizerID = defaultOrganizerID
+	}
+
+	inv := s.buildInvoice(ticket, organizerID)
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal invoice data: %w", err)
+	}
+	net, tax, gross := inv.Totals()
+
+	if _, err := s.dbService.Client.Prisma.ExecuteRaw(
+		issueInvoiceSQL,
+		organizerID,
+		ids.NewUUID(),
+		ticket.ID,
+		ticket.UserID,
+		s.config.NumberPrefix,
+		inv.Currency,
+		net,
+		tax,
+		gross,
+		string(data),
+	).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to allocate invoice number: %w", err)
+	}
+
+	created, err := s.dbService.Client.Invoice.FindUnique(
+		db.Invoice.TicketID.Equals(ticket.ID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load issued invoice: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id":      ticket.ID,
+		"organizer_id":   organizerID,
+		"invoice_number": created.Number,
+	}).Info("Invoice issued")
+
+	if _, err := s.PDF(ctx, created); err != nil {
+		// The number is allocated; the PDF is rendered again on first download
+		log.WithError(err).WithField("invoice_number", created.Number).Warn("Failed to store invoice PDF")
+	}
+
+	return created, nil
+}
+
+// PDF returns the stored PDF for an invoice, rendering and storing it first
+// if it has not been generated yet.
+func (s *InvoiceService) PDF(ctx context.Context, inv *db.InvoiceModel) ([]byte, error) {
+	if pdf, ok := inv.PDF(); ok && len(pdf) > 0 {
+		return pdf, nil
+	}
+
+	var data invoice.Invoice
+	if err := json.Unmarshal([]byte(inv.Data), &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal invoice data: %w", err)
+	}
+	data.Number = inv.Number
+	data.IssuedAt = inv.CreatedAt
+
+	pdf := invoice.Render(data)
+	if _, err := s.dbService.Client.Invoice.FindUnique(
+		db.Invoice.ID.Equals(inv.ID),
+	).Update(
+		db.Invoice.PDF.Set(pdf),
+	).Exec(ctx); err != nil {
+		return pdf, fmt.Errorf("failed to store invoice pdf: %w", err)
+	}
+
+	return pdf, nil
+}
+
+func (s *InvoiceService) buildInvoice(ticket *db.TicketModel, organizerID string) invoice.Invoice {
+	seller := s.config.SellerFor(organizerID)
+	buyerName, _ := ticket.BuyerName()
+	buyerEmail, _ := ticket.BuyerEmail()
+	if buyerName == "" {
+		buyerName = ticket.UserID
+	}
+
+	totalCents := invoice.ToCents(ticket.TotalPrice)
+	unitCents := totalCents / int64(ticket.Quantity)
+	lines := []invoice.LineItem{{
+		Description:    fmt.Sprintf("Ticket for event %s", ticket.EventID),
+		Quantity:       ticket.Quantity,
+		UnitPriceCents: unitCents,
+	}}
+	// Keep the invoice total equal to the charged amount when it doesn't divide evenly
+	if rest := totalCents - unitCents*int64(ticket.Quantity); rest != 0 {
+		lines = append(lines, invoice.LineItem{
+			Description:    "Rounding",
+			Quantity:       1,
+			UnitPriceCents: rest,
+		})
+	}
+
+	return invoice.Invoice{
+		IssuedAt:  ticket.UpdatedAt,
+		Currency:  s.config.Currency,
+		TaxRate:   s.config.TaxRate,
+		Reference: ticket.ID,
+		Seller: invoice.Party{
+			Name:    seller.Name,
+			Address: seller.Address,
+			VATID:   seller.VATID,
+			Email:   seller.Email,
+		},
+		Buyer: invoice.Party{
+			Name:  buyerName,
+			Email: buyerEmail,
+		},
+		Lines: lines,
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/envelope"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrAlreadyProcessed is returned when another delivery of a message committed
+// its state change first
+var ErrAlreadyProcessed = errors.New("message already processed")
+
+// MessageLedgerService records the messages whose state change the consumer
+// committed. The record is written in the same transaction as the change, so
+// a redelivered or duplicated message finds it and is ignored, also when two
+// consumers race on the same message.
+type MessageLedgerService struct {
+	dbService *DatabaseService
+	config    *configs.ConsumerConfig
+}
+
+func NewMessageLedgerService(cfg *configs.Config, dbService *DatabaseService) *MessageLedgerService {
+	return &MessageLedgerService{
+		dbService: dbService,
+		config:    &cfg.Consumer,
+	}
+}
+
+// ledgerKey identifies a message; message IDs are only unique per type
+func ledgerKey(env envelope.Envelope) string {
+	return env.Type + ":" + env.ID
+}
+
+// Processed reports whether the state change of a message was committed.
+// Messages without an ID can't be told apart and are never reported.
+func (s *MessageLedgerService) Processed(ctx context.Context, env envelope.Envelope) (bool, error) {
+	if env.ID == "" {
+		return false, nil
+	}
+
+	_, err := s.dbService.Client.ProcessedMessage.FindUnique(
+		db.ProcessedMessage.DedupeKey.Equals(ledgerKey(env)),
+	).Exec(ctx)
+
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, db.ErrNotFound):
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to look up processed message %s: %w", env.ID, err)
+	}
+}
+
+// Commit runs the state change of a message in one transaction with its
+// record. If another delivery recorded the message first, nothing is changed
+// and ErrAlreadyProcessed is returned.
+func (s *MessageLedgerService) Commit(ctx context.Context, env envelope.Envelope, changes ...db.PrismaTransaction) error {
+	txs := changes
+	if env.ID != "" {
+		txs = append(txs, s.dbService.Client.ProcessedMessage.CreateOne(
+			db.ProcessedMessage.DedupeKey.Set(ledgerKey(env)),
+			db.ProcessedMessage.MessageID.Set(env.ID),
+			db.ProcessedMessage.MessageType.Set(env.Type),
+		).Tx())
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+
+	if err := s.dbService.Client.Prisma.Transaction(txs...).Exec(ctx); err != nil {
+		if _, ok := db.IsErrUniqueConstraint(err); ok {
+			return ErrAlreadyProcessed
+		}
+		return err
+	}
+	return nil
+}
+
+// Run removes records older than the retention period until ctx is cancelled
+func (s *MessageLedgerService) Run(ctx context.Context) {
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cleanup.C:
+			if err := s.cleanup(ctx); err != nil {
+				log.WithError(err).Error("Failed to clean up processed messages")
+			}
+		}
+	}
+}
+
+func (s *MessageLedgerService) cleanup(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	cutoff := time.Now().Add(-time.Duration(s.config.ProcessedRetentionHours) * time.Hour)
+	result, err := s.dbService.Client.ProcessedMessage.FindMany(
+		db.ProcessedMessage.ProcessedAt.Lt(cutoff),
+	).Delete().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	if result.Count > 0 {
+		log.WithField("count", result.Count).Info("Cleaned up processed messages")
+	}
+	return nil
+}
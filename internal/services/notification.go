@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/notifications"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnknownNotification is returned for notification types without a template
+var ErrUnknownNotification = errors.New("unknown notification type")
+
+// ErrNotificationRejected is returned when the mail server rejected a
+// notification for good, e.g. an unknown mailbox; sending again won't help
+var ErrNotificationRejected = errors.New("notification rejected")
+
+// sendingLease is how long a notification claimed for sending is left to its
+// delivery before another one may take it over, e.g. after a crash
+const sendingLease = 5 * time.Minute
+
+type NotificationService struct {
+	dbService *DatabaseService
+	sender    notifications.Sender
+	config    *configs.NotificationsConfig
+	currency  string
+}
+
+func NewNotificationService(cfg *configs.Config, dbService *DatabaseService, sender notifications.Sender) *NotificationService {
+	return &NotificationService{
+		dbService: dbService,
+		sender:    sender,
+		config:    &cfg.Notifications,
+		currency:  cfg.Payment.Currency,
+	}
+}
+
+// Notify emails the ticket holder about a ticket change. Every notification
+// is recorded under a dedupe key, so redelivered or duplicated messages don't
+// send the same email twice. A delivery claims the record (status sending)
+// before sending, so two deliveries of the same notification can't both
+// send it.
+func (s *NotificationService) Notify(ctx context.Context, ticket *db.TicketModel, msg types.TicketNotificationMessage) error {
+	kind, dedupeKey, err := classifyNotification(msg)
+	if err != nil {
+		return err
+	}
+
+	recipient, ok := ticket.BuyerEmail()
+	if !ok || recipient == "" {
+		log.WithFields(log.Fields{
+			"ticket_id": ticket.ID,
+			"kind":      kind,
+		}).Warn("No email address for ticket holder, skipping notification")
+		return nil
+	}
+
+	record, err := s.dbService.Client.Notification.FindUnique(
+		db.Notification.DedupeKey.Equals(dedupeKey),
+	).Exec(ctx)
+
+	switch {
+	case err == nil && record.Status == "sent":
+		log.WithField("dedupe_key", dedupeKey).Info("Notification already sent, skipping")
+		return nil
+	case err == nil:
+		// Pending or failed, or left sending by a delivery that didn't finish
+		claimed, err := s.dbService.Client.Notification.FindMany(
+			db.Notification.ID.Equals(record.ID),
+			db.Notification.Or(
+				db.Notification.Status.In([]string{"pending", "failed"}),
+				db.Notification.And(
+					db.Notification.Status.Equals("sending"),
+					db.Notification.UpdatedAt.Lt(time.Now().Add(-sendingLease)),
+				),
+			),
+		).Update(
+			db.Notification.Status.Set("sending"),
+		).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to claim notification %s: %w", dedupeKey, err)
+		}
+		if claimed.Count == 0 {
+			// That delivery retries on its own if sending fails
+			log.WithField("dedupe_key", dedupeKey).Info("Notification is being sent by another delivery, skipping")
+			return nil
+		}
+	case errors.Is(err, db.ErrNotFound):
+		record, err = s.dbService.Client.Notification.CreateOne(
+			db.Notification.TicketID.Set(ticket.ID),
+			db.Notification.Kind.Set(string(kind)),
+			db.Notification.DedupeKey.Set(dedupeKey),
+			db.Notification.Recipient.Set(recipient),
+			db.Notification.Status.Set("sending"),
+		).Exec(ctx)
+		if err != nil {
+			// Most likely a concurrent delivery of the same notification; retry later
+			return fmt.Errorf("failed to record notification %s: %w", dedupeKey, err)
+		}
+	default:
+		return fmt.Errorf("failed to look up notification %s: %w", dedupeKey, err)
+	}
+
+	buyerName, _ := ticket.BuyerName()
+	if buyerName == "" {
+		buyerName = "there"
+	}
+	eventName, _ := ticket.EventName()
+	if eventName == "" {
+		eventName = ticket.EventID
+	}
+	var startsAt *time.Time
+	if t, ok := ticket.EventStartsAt(); ok {
+		startsAt = &t
+	}
+
+	email, err := notifications.Render(kind, recipient, notifications.TemplateData{
+		BuyerName:     buyerName,
+		TicketID:      ticket.ID,
+		EventName:     eventName,
+		EventStartsAt: startsAt,
+		Quantity:      ticket.Quantity,
+		TotalPrice:    ticket.TotalPrice,
+		Currency:      s.currency,
+		Reason:        msg.Reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Duration(s.config.RetryBackoffMs) * time.Millisecond
+	attempts, sendErr := notifications.SendWithRetry(ctx, s.sender, email, s.config.MaxAttempts, backoff)
+
+	update := []db.NotificationSetParam{
+		db.Notification.Attempts.Increment(attempts),
+	}
+	if sendErr != nil {
+		update = append(update,
+			db.Notification.Status.Set("failed"),
+			db.Notification.LastError.Set(sendErr.Error()),
+		)
+	} else {
+		update = append(update,
+			db.Notification.Status.Set("sent"),
+			db.Notification.SentAt.Set(time.Now()),
+		)
+	}
+
+	if _, err := s.dbService.Client.Notification.FindUnique(
+		db.Notification.ID.Equals(record.ID),
+	).Update(update...).Exec(ctx); err != nil {
+		log.WithError(err).WithField("dedupe_key", dedupeKey).Error("Failed to update notification record")
+	}
+
+	if sendErr != nil {
+		if notifications.IsPermanent(sendErr) {
+			return fmt.Errorf("%w: %s notification to %s: %v", ErrNotificationRejected, kind, recipient, sendErr)
+		}
+		return fmt.Errorf("failed to send %s notification: %w", kind, sendErr)
+	}
+
+	log.WithFields(log.Fields{
+		"ticket_id": ticket.ID,
+		"kind":      kind,
+		"attempts":  attempts,
+	}).Info("Notification sent")
+
+	return nil
+}
+
+// classifyNotification maps a notification message to a template and dedupe key
+func classifyNotification(msg types.TicketNotificationMessage) (notifications.Kind, string, error) {
+	switch msg.Type {
+	case types.NotificationTicketConfirmed:
+		return notifications.KindConfirmation, "confirmation:" + msg.TicketID, nil
+	case types.NotificationTicketCancelled:
+		return notifications.KindCancellation, "cancellation:" + msg.TicketID, nil
+	case types.NotificationEventCancelled:
+		if msg.Status == "refunded" {
+			return notifications.KindRefund, "refund:" + msg.TicketID, nil
+		}
+		return notifications.KindCancellation, "cancellation:" + msg.TicketID, nil
+	case types.NotificationEventRescheduled:
+		// Each new date is a separate notification
+		var startsAt int64
+		if msg.EventStartsAt != nil {
+			startsAt = msg.EventStartsAt.Unix()
+		}
+		return notifications.KindRescheduled, fmt.Sprintf("rescheduled:%s:%d", msg.TicketID, startsAt), nil
+	default:
+		return "", "", fmt.Errorf("%w: %s", ErrUnknownNotification, msg.Type)
+	}
+}
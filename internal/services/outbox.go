@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/broker"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/ids"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/metrics"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/webhook"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// outboxServiceName labels metrics recorded by the outbox relay
+const outboxServiceName = "dws-ticket-service"
+
+// retryMessageSQL inserts a ticket purchased message only if the ticket is
+// pending with the given payment attempt, so a retry whose status change
+// matched nothing in the same transaction leaves no message behind
+const retryMessageSQL = `
+INSERT INTO outbox_messages ("id", "aggregateId", "exchange", "routingKey", "payload")
+SELECT $1, $2, $3, $4, $5
+WHERE EXISTS (
+	SELECT 1 FROM tickets WHERE "id" = $2 AND "status" = 'pending' AND "paymentAttempt" = $6
+)`
+
+// statusMessageSQL inserts a message only while the ticket has the given
+// status. It locks the ticket row, so a conditional update on the same status
+// later in the transaction sees the same row: both apply or neither does.
+const statusMessageSQL = `
+INSERT INTO outbox_messages ("id", "aggregateId", "exchange", "routingKey", "payload")
+SELECT $1, $2, $3, $4, $5
+WHERE EXISTS (
+	SELECT 1 FROM tickets WHERE "id" = $2 AND "status" = $6 FOR UPDATE
+)`
+
+// outboxLease is how long a claimed message is hidden from other relays
+// while it is being published
+const outboxLease = 30 * time.Second
+
+// OutboxService writes RabbitMQ messages to the outbox table in the same
+// transaction as the ticket change they announce, and relays them to the
+// broker afterwards. A message is never lost when the broker is unavailable;
+// it may be published more than once if the relay dies between publishing
+// and marking it published.
+type OutboxService struct {
+	dbService  *DatabaseService
+	rmqService broker.Publisher
+	rmqConfig  *configs.RabbitMQConfig
+	config     *configs.OutboxConfig
+	wake       chan struct{}
+}
+
+func NewOutboxService(cfg *configs.Config, dbService *DatabaseService, rmqService broker.Publisher) *OutboxService {
+	return &OutboxService{
+		dbService:  dbService,
+		rmqService: rmqService,
+		rmqConfig:  &cfg.RabbitMQ,
+		config:     &cfg.Outbox,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// TicketPurchased returns the outbox insert for a ticket purchased message,
+// to be run in a transaction with the ticket write
+func (s *OutboxService) TicketPurchased(msg types.TicketMessage) (db.OutboxMessageUniqueTxResult, error) {
+	return s.message(msg.TicketID, s.rmqConfig.Exchange, s.rmqConfig.Queue.Purchased, msg)
+}
+
+// PaymentRetried returns the outbox insert for the ticket purchased message
+// of a payment retry, to be run in a transaction after the conditional status
+// change. The message is only written if that change moved the ticket to
+// pending with msg.PaymentAttempt.
+func (s *OutboxService) PaymentRetried(msg types.TicketMessage) (db.PrismaTransaction, error) {
+	return s.conditionalMessage(retryMessageSQL, msg.TicketID, s.rmqConfig.Queue.Purchased, msg, msg.PaymentAttempt)
+}
+
+// TicketNotification returns the outbox insert for a ticket notification
+// message, to be run in a transaction with the ticket write
+func (s *OutboxService) TicketNotification(msg types.TicketNotificationMessage) (db.OutboxMessageUniqueTxResult, error) {
+	return s.message(msg.TicketID, s.rmqConfig.Exchange, s.rmqConfig.Queue.Notification, msg)
+}
+
+// TicketNotificationIfStatus returns the outbox insert for a ticket
+// notification message that is only written while the ticket has the given
+// status. Run it before the ticket update conditional on the same status.
+func (s *OutboxService) TicketNotificationIfStatus(msg types.TicketNotificationMessage, status string) (db.PrismaTransaction, error) {
+	return s.conditionalMessage(statusMessageSQL, msg.TicketID, s.rmqConfig.Queue.Notification, msg, status)
+}
+
+func (s *OutboxService) conditionalMessage(query, ticketID, routingKey string, msg, condition interface{}) (db.PrismaTransaction, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outbox message: %w", err)
+	}
+
+	return s.dbService.Client.Prisma.ExecuteRaw(
+		query,
+		ids.NewUUID(),
+		ticketID,
+		s.rmqConfig.Exchange,
+		routingKey,
+		string(payload),
+		condition,
+	).Tx(), nil
+}
+
+func (s *OutboxService) message(aggregateID, exchange, routingKey string, msg interface{}) (db.OutboxMessageUniqueTxResult, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return db.OutboxMessageUniqueTxResult{}, fmt.Errorf("failed to marshal outbox message: %w", err)
+	}
+
+	return s.dbService.Client.OutboxMessage.CreateOne(
+		db.OutboxMessage.AggregateID.Set(aggregateID),
+		db.OutboxMessage.Exchange.Set(exchange),
+		db.OutboxMessage.RoutingKey.Set(routingKey),
+		db.OutboxMessage.Payload.Set(string(payload)),
+	).Tx(), nil
+}
+
+// Notify wakes the relay after a transaction with outbox messages committed,
+// so they are published without waiting for the next poll
+func (s *OutboxService) Notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays outbox messages until ctx is cancelled and periodically removes
+// published ones. A full batch is followed immediately by the next one so a
+// backlog drains quickly.
+func (s *OutboxService) Run(ctx context.Context) {
+	interval := time.Duration(s.config.PollIntervalSeconds) * time.Second
+	log.WithField("interval", interval).Info("Outbox relay started")
+
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		published, err := s.relayBatch(ctx)
+		if err != nil {
+			log.WithError(err).Error("Failed to relay outbox messages")
+		}
+
+		wait := interval
+		if published >= s.config.BatchSize {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("Outbox relay stopped")
+			return
+		case <-s.wake:
+		case <-cleanup.C:
+			if err := s.cleanup(ctx); err != nil {
+				log.WithError(err).Error("Failed to clean up outbox messages")
+			}
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *OutboxService) relayBatch(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	return s.Relay(ctx)
+}
+
+// Relay publishes pending outbox messages in sequence order and returns how
+// many were published. Messages of a ticket are published strictly in order:
+// once one of them can't be published (not due yet, claimed by another
+// replica or failing), the ticket's later messages wait for the next round.
+func (s *OutboxService) Relay(ctx context.Context) (int, error) {
+	messages, err := s.dbService.Client.OutboxMessage.FindMany(
+		db.OutboxMessage.Status.Equals("pending"),
+	).OrderBy(
+		db.OutboxMessage.Sequence.Order(db.ASC),
+	).Take(s.config.BatchSize).Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch outbox messages: %w", err)
+	}
+
+	now := time.Now()
+	blocked := make(map[string]bool)
+	published := 0
+	for i := range messages {
+		msg := &messages[i]
+		if blocked[msg.AggregateID] {
+			continue
+		}
+		if msg.NextAttemptAt.After(now) || !s.publish(ctx, msg) {
+			blocked[msg.AggregateID] = true
+			continue
+		}
+		published++
+	}
+	return published, nil
+}
+
+// publish claims a message, sends it and records the outcome. The claim
+// pushes nextAttemptAt out so other replicas treat the message, and with it
+// the rest of its ticket's messages, as not due.
+func (s *OutboxService) publish(ctx context.Context, msg *db.OutboxMessageModel) bool {
+	claimed, err := s.dbService.Client.OutboxMessage.FindMany(
+		db.OutboxMessage.ID.Equals(msg.ID),
+		db.OutboxMessage.Status.Equals("pending"),
+		db.OutboxMessage.NextAttemptAt.Equals(msg.NextAttemptAt),
+	).Update(
+		db.OutboxMessage.NextAttemptAt.Set(time.Now().Add(outboxLease)),
+	).Exec(ctx)
+	if err != nil {
+		log.WithError(err).WithField("outbox_id", msg.ID).Error("Failed to claim outbox message")
+		return false
+	}
+	if claimed.Count == 0 {
+		return false
+	}
+
+	entry := log.WithFields(log.Fields{
+		"outbox_id":   msg.ID,
+		"ticket_id":   msg.AggregateID,
+		"routing_key": msg.RoutingKey,
+	})
+
+	if sendErr := s.rmqService.PublishOutboxMessage(ctx, msg.Exchange, msg.RoutingKey, msg.ID, msg.AggregateID, []byte(msg.Payload)); sendErr != nil {
+		attempts := msg.Attempts + 1
+		backoff := webhook.Backoff(attempts,
+			time.Duration(s.config.BaseBackoffSeconds)*time.Second,
+			time.Duration(s.config.MaxBackoffSeconds)*time.Second,
+		)
+		if _, err := s.dbService.Client.OutboxMessage.FindUnique(
+			db.OutboxMessage.ID.Equals(msg.ID),
+		).Update(
+			db.OutboxMessage.Attempts.Set(attempts),
+			db.OutboxMessage.LastError.Set(sendErr.Error()),
+			db.OutboxMessage.NextAttemptAt.Set(time.Now().Add(backoff)),
+		).Exec(ctx); err != nil {
+			entry.WithError(err).Error("Failed to update outbox message")
+		}
+
+		metrics.OutboxMessages.WithLabelValues("retrying", outboxServiceName).Inc()
+		entry.WithError(sendErr).WithField("attempts", attempts).Warn("Failed to publish outbox message")
+		return false
+	}
+
+	if _, err := s.dbService.Client.OutboxMessage.FindUnique(
+		db.OutboxMessage.ID.Equals(msg.ID),
+	).Update(
+		db.OutboxMessage.Status.Set("published"),
+		db.OutboxMessage.PublishedAt.Set(time.Now()),
+	).Exec(ctx); err != nil {
+		// Published again once the lease expires
+		entry.WithError(err).Error("Failed to mark outbox message published")
+		return false
+	}
+
+	metrics.OutboxMessages.WithLabelValues("published", outboxServiceName).Inc()
+	entry.Debug("Published outbox message")
+	return true
+}
+
+// cleanup deletes messages published longer ago than the retention period
+func (s *OutboxService) cleanup(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	cutoff := time.Now().Add(-time.Duration(s.config.RetentionHours) * time.Hour)
+	result, err := s.dbService.Client.OutboxMessage.FindMany(
+		db.OutboxMessage.Status.Equals("published"),
+		db.OutboxMessage.PublishedAt.Lt(cutoff),
+	).Delete().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	if result.Count > 0 {
+		log.WithField("count", result.Count).Info("Cleaned up published outbox messages")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/wallet"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// WalletService issues Apple and Google Wallet passes for tickets. Either
+// wallet is disabled when its credentials are not configured.
+type WalletService struct {
+	apple      *wallet.AppleSigner
+	google     *wallet.GoogleSigner
+	issuerName string
+}
+
+func NewWalletService(cfg *configs.Config) *WalletService {
+	apple, err := wallet.NewAppleSigner(cfg.Wallet.Apple)
+	if err != nil {
+		logWalletDisabled("apple", err)
+	}
+
+	google, err := wallet.NewGoogleSigner(cfg.Wallet.Google)
+	if err != nil {
+		logWalletDisabled("google", err)
+	}
+
+	return &WalletService{
+		apple:      apple,
+		google:     google,
+		issuerName: cfg.Wallet.Apple.OrganizationName,
+	}
+}
+
+func logWalletDisabled(provider string, err error) {
+	entry := log.WithField("wallet", provider)
+	if errors.Is(err, wallet.ErrNotConfigured) {
+		entry.Info("Wallet passes disabled, no credentials configured")
+		return
+	}
+	entry.WithError(err).Warn("Wallet passes disabled, failed to load credentials")
+}
+
+// ApplePass returns the signed .pkpass bundle for a ticket
+func (s *WalletService) ApplePass(ticket *db.TicketModel) ([]byte, error) {
+	if s.apple == nil {
+		return nil, wallet.ErrNotConfigured
+	}
+	return s.apple.PKPass(walletTicket(ticket))
+}
+
+// GoogleSaveLink returns the "Add to Google Wallet" link for a ticket
+func (s *WalletService) GoogleSaveLink(ticket *db.TicketModel) (string, error) {
+	if s.google == nil {
+		return "", wallet.ErrNotConfigured
+	}
+	return s.google.SaveLink(walletTicket(ticket), s.issuerName)
+}
+
+func walletTicket(ticket *db.TicketModel) wallet.Ticket {
+	eventName, _ := ticket.EventName()
+	location, _ := ticket.EventLocation()
+	holderName, _ := ticket.BuyerName()
+
+	t := wallet.Ticket{
+		ID:         ticket.ID,
+		EventID:    ticket.EventID,
+		EventName:  eventName,
+		Location:   location,
+		HolderName: holderName,
+		Quantity:   ticket.Quantity,
+	}
+	if startsAt, ok := ticket.EventStartsAt(); ok {
+		t.StartsAt = &startsAt
+	}
+	if endsAt, ok := ticket.EventEndsAt(); ok {
+		t.EndsAt = &endsAt
+	}
+	return t
+}
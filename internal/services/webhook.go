@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/oskargbc/dws-ticket-service/configs"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/ids"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/metrics"
+	"github.com/oskargbc/dws-ticket-service/internal/pkg/webhook"
+	"github.com/oskargbc/dws-ticket-service/internal/types"
+	"github.com/oskargbc/dws-ticket-service/prisma/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// webhookServiceName labels metrics recorded by the webhook worker
+const webhookServiceName = "dws-ticket-service-webhooks"
+
+type WebhookService struct {
+	dbService  *DatabaseService
+	config     *configs.WebhooksConfig
+	httpClient *http.Client
+}
+
+func NewWebhookService(cfg *configs.Config, dbService *DatabaseService) *WebhookService {
+	return &WebhookService{
+		dbService:  dbService,
+		config:     &cfg.Webhooks,
+		httpClient: webhook.NewHTTPClient(time.Duration(cfg.Webhooks.TimeoutSeconds) * time.Second),
+	}
+}
+
+// Enqueue records a delivery of eventType for every active subscription of
+// the ticket's organiser that selected it. Deliveries are keyed per
+// subscription, event type and ticket, so enqueueing again on a redelivered
+// message is a no-op.
+func (s *WebhookService) Enqueue(ctx context.Context, eventType string, ticket *db.TicketModel) error {
+	organizerID, ok := ticket.OrganizerID()
+	if !ok || organizerID == "" {
+		return nil
+	}
+
+	subscriptions, err := s.dbService.Client.WebhookSubscription.FindMany(
+		db.WebhookSubscription.OrganizerID.Equals(organizerID),
+		db.WebhookSubscription.Active.Equals(true),
+	).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch webhook subscriptions: %w", err)
+	}
+
+	for _, sub := range subscriptions {
+		if !hasEventType(sub.EventTypes, eventType) {
+			continue
+		}
+
+		dedupeKey := fmt.Sprintf("%s:%s:%s", sub.ID, eventType, ticket.ID)
+		if _, err := s.dbService.Client.WebhookDelivery.FindUnique(
+			db.WebhookDelivery.DedupeKey.Equals(dedupeKey),
+		).Exec(ctx); err == nil {
+			continue
+		} else if !errors.Is(err, db.ErrNotFound) {
+			return fmt.Errorf("failed to look up webhook delivery %s: %w", dedupeKey, err)
+		}
+
+		deliveryID := ids.NewUUID()
+		payload, err := json.Marshal(types.WebhookEvent{
+			ID:        deliveryID,
+			Type:      eventType,
+			CreatedAt: time.Now().UTC(),
+			Data:      webhookTicketData(ticket),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal webhook payload: %w", err)
+		}
+
+		if _, err := s.dbService.Client.WebhookDelivery.CreateOne(
+			db.WebhookDelivery.SubscriptionID.Set(sub.ID),
+			db.WebhookDelivery.OrganizerID.Set(organizerID),
+			db.WebhookDelivery.EventType.Set(eventType),
+			db.WebhookDelivery.TicketID.Set(ticket.ID),
+			db.WebhookDelivery.DedupeKey.Set(dedupeKey),
+			db.WebhookDelivery.Payload.Set(string(payload)),
+			db.WebhookDelivery.ID.Set(deliveryID),
+		).Exec(ctx); err != nil {
+			if _, ok := db.IsErrUniqueConstraint(err); ok {
+				// Enqueued concurrently
+				continue
+			}
+			return fmt.Errorf("failed to record webhook delivery %s: %w", dedupeKey, err)
+		}
+
+		log.WithFields(log.Fields{
+			"delivery_id":     deliveryID,
+			"subscription_id": sub.ID,
+			"event_type":      eventType,
+			"ticket_id":       ticket.ID,
+		}).Info("Webhook delivery enqueued")
+	}
+
+	return nil
+}
+
+// Redeliver schedules a delivery to be sent again right away with a fresh
+// retry budget, whatever its current status
+func (s *WebhookService) Redeliver(ctx context.Context, deliveryID string) (*db.WebhookDeliveryModel, error) {
+	return s.dbService.Client.WebhookDelivery.FindUnique(
+		db.WebhookDelivery.ID.Equals(deliveryID),
+	).Update(
+		db.WebhookDelivery.Status.Set("pending"),
+		db.WebhookDelivery.Attempts.Set(0),
+		db.WebhookDelivery.NextAttemptAt.Set(time.Now()),
+	).Exec(ctx)
+}
+
+// DeliverDue sends pending deliveries whose next attempt is due and returns
+// how many were attempted
+func (s *WebhookService) DeliverDue(ctx context.Context) (int, error) {
+	now := time.Now()
+	deliveries, err := s.dbService.Client.WebhookDelivery.FindMany(
+		db.WebhookDelivery.Status.Equals("pending"),
+		db.WebhookDelivery.NextAttemptAt.Lte(now),
+	).OrderBy(
+		db.WebhookDelivery.NextAttemptAt.Order(db.ASC),
+	).Take(s.config.BatchSize).Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch due webhook deliveries: %w", err)
+	}
+
+	attempted := 0
+	for i := range deliveries {
+		if s.attempt(ctx, &deliveries[i]) {
+			attempted++
+		}
+	}
+	return attempted, nil
+}
+
+// attempt claims a delivery and sends it. The claim pushes nextAttemptAt past
+// the request timeout so other worker replicas skip it while it is in flight.
+func (s *WebhookService) attempt(ctx context.Context, delivery *db.WebhookDeliveryModel) bool {
+	lease := time.Now().Add(2 * s.httpClient.Timeout)
+	claimed, err := s.dbService.Client.WebhookDelivery.FindMany(
+		db.WebhookDelivery.ID.Equals(delivery.ID),
+		db.WebhookDelivery.Status.Equals("pending"),
+		db.WebhookDelivery.NextAttemptAt.Equals(delivery.NextAttemptAt),
+	).Update(
+		db.WebhookDelivery.NextAttemptAt.Set(lease),
+	).Exec(ctx)
+	if err != nil {
+		log.WithError(err).WithField("delivery_id", delivery.ID).Error("Failed to claim webhook delivery")
+		return false
+	}
+	if claimed.Count == 0 {
+		return false
+	}
+
+	sub, err := s.dbService.Client.WebhookSubscription.FindUnique(
+		db.WebhookSubscription.ID.Equals(delivery.SubscriptionID),
+	).Exec(ctx)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			s.recordAttempt(ctx, delivery, 0, errors.New("subscription deleted"), true)
+		} else {
+			log.WithError(err).WithField("delivery_id", delivery.ID).Error("Failed to load webhook subscription")
+		}
+		return false
+	}
+	if !sub.Active {
+		s.recordAttempt(ctx, delivery, 0, errors.New("subscription disabled"), true)
+		return false
+	}
+
+	statusCode, sendErr := webhook.Deliver(ctx, s.httpClient, sub.URL, sub.Secret, delivery.EventType, delivery.ID, []byte(delivery.Payload))
+	// An internal address won't become deliverable by retrying
+	s.recordAttempt(ctx, delivery, statusCode, sendErr, errors.Is(sendErr, webhook.ErrForbiddenAddress))
+	return true
+}
+
+// recordAttempt stores the outcome of an attempt in the delivery log and
+// schedules the next one with exponential backoff
+func (s *WebhookService) recordAttempt(ctx context.Context, delivery *db.WebhookDeliveryModel, statusCode int, sendErr error, giveUp bool) {
+	attempts := delivery.Attempts + 1
+	update := []db.WebhookDeliverySetParam{
+		db.WebhookDelivery.Attempts.Set(attempts),
+	}
+	if statusCode != 0 {
+		update = append(update, db.WebhookDelivery.LastStatusCode.Set(statusCode))
+	}
+
+	var outcome string
+	switch {
+	case sendErr == nil:
+		outcome = "delivered"
+		update = append(update,
+			db.WebhookDelivery.Status.Set("delivered"),
+			db.WebhookDelivery.DeliveredAt.Set(time.Now()),
+		)
+	case giveUp || attempts >= s.config.MaxAttempts:
+		outcome = "failed"
+		update = append(update,
+			db.WebhookDelivery.Status.Set("failed"),
+			db.WebhookDelivery.LastError.Set(sendErr.Error()),
+		)
+	default:
+		outcome = "retrying"
+		backoff := webhook.Backoff(attempts,
+			time.Duration(s.config.BaseBackoffSeconds)*time.Second,
+			time.Duration(s.config.MaxBackoffSeconds)*time.Second,
+		)
+		update = append(update,
+			db.WebhookDelivery.LastError.Set(sendErr.Error()),
+			db.WebhookDelivery.NextAttemptAt.Set(time.Now().Add(backoff)),
+		)
+	}
+
+	if _, err := s.dbService.Client.WebhookDelivery.FindUnique(
+		db.WebhookDelivery.ID.Equals(delivery.ID),
+	).Update(update...).Exec(ctx); err != nil {
+		log.WithError(err).WithField("delivery_id", delivery.ID).Error("Failed to update webhook delivery")
+	}
+
+	metrics.WebhookDeliveries.WithLabelValues(outcome, webhookServiceName).Inc()
+
+	entry := log.WithFields(log.Fields{
+		"delivery_id": delivery.ID,
+		"event_type":  delivery.EventType,
+		"attempts":    attempts,
+		"status_code": statusCode,
+		"outcome":     outcome,
+	})
+	if sendErr != nil {
+		entry.WithError(sendErr).Warn("Webhook delivery attempt failed")
+		return
+	}
+	entry.Info("Webhook delivered")
+}
+
+// SplitEventTypes parses the comma-separated event types of a subscription
+func SplitEventTypes(eventTypes string) []string {
+	var out []string
+	for _, t := range strings.Split(eventTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func hasEventType(eventTypes, eventType string) bool {
+	for _, t := range SplitEventTypes(eventTypes) {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookTicketData(ticket *db.TicketModel) types.TicketResponse {
+	failureReason, _ := ticket.FailureReason()
+	return types.TicketResponse{
+		ID:            ticket.ID,
+		UserID:        ticket.UserID,
+		EventID:       ticket.EventID,
+		Quantity:      ticket.Quantity,
+		TotalPrice:    ticket.TotalPrice,
+		Status:        ticket.Status,
+		FailureReason: failureReason,
+		CreatedAt:     ticket.CreatedAt,
+		UpdatedAt:     ticket.UpdatedAt,
+	}
+}
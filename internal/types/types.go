@@ -1,36 +1,224 @@
 package types
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // PurchaseRequest represents a ticket purchase request
 type PurchaseRequest struct {
-	EventID    string  `json:"event_id" binding:"required"`
-	Quantity   int     `json:"quantity" binding:"required,min=1,max=10"`
-	TotalPrice float64 `json:"total_price" binding:"required,min=0"`
+	EventID       string  `json:"event_id" binding:"required"`
+	Quantity      int     `json:"quantity" binding:"required,min=1,max=10"`
+	TotalPrice    float64 `json:"total_price" binding:"required,min=0"`
+	PaymentMethod string  `json:"payment_method,omitempty"`
 }
 
 // TicketResponse represents a ticket in API responses
 type TicketResponse struct {
-	ID         string    `json:"id"`
-	UserID     string    `json:"user_id"`
-	EventID    string    `json:"event_id"`
-	Quantity   int       `json:"quantity"`
-	TotalPrice float64   `json:"total_price"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID            string    `json:"id"`
+	UserID        string    `json:"user_id"`
+	EventID       string    `json:"event_id"`
+	Quantity      int       `json:"quantity"`
+	TotalPrice    float64   `json:"total_price"`
+	Status        string    `json:"status"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Message types set in the envelope of broker messages
+const (
+	MessageTypeTicketPurchased     = "dws.ticket.purchased"
+	MessageTypeTicketConfirmed     = "dws.ticket.confirmed"
+	MessageTypeTicketPaymentFailed = "dws.ticket.payment_failed"
+	MessageTypeTicketNotification  = "dws.ticket.notification"
+	MessageTypeTicketStatus        = "dws.ticket.status"
+	MessageTypeEventCancelled      = "dws.event.cancelled"
+	MessageTypeEventRescheduled    = "dws.event.rescheduled"
+)
+
+// MessageSchemaVersions is the schema version of each message type's current
+// struct. Bump it, and register a decoder for the previous version in the
+// consumer, when a change would break existing consumers.
+var MessageSchemaVersions = map[string]int{
+	MessageTypeTicketPurchased:     1,
+	MessageTypeTicketConfirmed:     1,
+	MessageTypeTicketPaymentFailed: 1,
+	MessageTypeTicketNotification:  1,
+	MessageTypeTicketStatus:        1,
 }
 
 // TicketMessage represents a message published to RabbitMQ
 type TicketMessage struct {
+	TicketID       string    `json:"ticket_id"`
+	UserID         string    `json:"user_id"`
+	EventID        string    `json:"event_id"`
+	Quantity       int       `json:"quantity"`
+	TotalPrice     float64   `json:"total_price"`
+	Timestamp      time.Time `json:"timestamp"`
+	PaymentMethod  string    `json:"payment_method,omitempty"`
+	PaymentAttempt int       `json:"payment_attempt,omitempty"`
+}
+
+// TicketConfirmedMessage is published once a ticket's payment is captured.
+// It is published at least once; the message ID is the same for every
+// publish of a ticket's confirmation.
+type TicketConfirmedMessage struct {
+	TicketID    string    `json:"ticket_id"`
+	UserID      string    `json:"user_id"`
+	EventID     string    `json:"event_id"`
+	OrganizerID string    `json:"organizer_id,omitempty"`
+	Quantity    int       `json:"quantity"`
+	TotalPrice  float64   `json:"total_price"`
+	PaymentID   string    `json:"payment_id"`
+	ConfirmedAt time.Time `json:"confirmed_at"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// TicketPaymentFailedMessage is published when a ticket's payment is declined
+type TicketPaymentFailedMessage struct {
 	TicketID   string    `json:"ticket_id"`
 	UserID     string    `json:"user_id"`
 	EventID    string    `json:"event_id"`
 	Quantity   int       `json:"quantity"`
-	TotalPrice float64   `json:"total_price"`
+	ReasonCode string    `json:"reason_code"`
+	Reason     string    `json:"reason,omitempty"`
 	Timestamp  time.Time `json:"timestamp"`
 }
 
+// EventLifecycleMessage is published by dws-event-service when an event is
+// cancelled or rescheduled
+type EventLifecycleMessage struct {
+	EventID   string     `json:"event_id"`
+	Reason    string     `json:"reason,omitempty"`
+	StartDate *time.Time `json:"start_date,omitempty"`
+	EndDate   *time.Time `json:"end_date,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+}
+
+// TicketNotificationMessage asks for the ticket holder to be notified about a
+// change to their ticket
+type TicketNotificationMessage struct {
+	Type          string     `json:"type"`
+	TicketID      string     `json:"ticket_id"`
+	UserID        string     `json:"user_id"`
+	EventID       string     `json:"event_id"`
+	Status        string     `json:"status"`
+	Reason        string     `json:"reason,omitempty"`
+	EventStartsAt *time.Time `json:"event_starts_at,omitempty"`
+	Timestamp     time.Time  `json:"timestamp"`
+}
+
+// TicketStatusMessage is fanned out to every API replica whenever a ticket
+// changes status, to drive live status streams
+type TicketStatusMessage struct {
+	TicketID       string    `json:"ticket_id"`
+	UserID         string    `json:"user_id"`
+	EventID        string    `json:"event_id"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	FailureReason  string    `json:"failure_reason,omitempty"`
+	Quantity       int       `json:"quantity"`
+	TotalPrice     float64   `json:"total_price"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// Ticket notification types
+const (
+	NotificationTicketConfirmed  = "ticket_confirmed"
+	NotificationTicketCancelled  = "ticket_cancelled"
+	NotificationEventCancelled   = "event_cancelled"
+	NotificationEventRescheduled = "event_rescheduled"
+)
+
+// RetryPaymentRequest represents a request to retry payment on a failed ticket
+type RetryPaymentRequest struct {
+	PaymentMethod string `json:"payment_method,omitempty"`
+}
+
+// CreateWebhookRequest registers an organiser endpoint for ticket events
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required,url"`
+	EventTypes []string `json:"event_types" binding:"required,min=1"`
+}
+
+// WebhookResponse represents a webhook subscription. The secret is only
+// included when the subscription is created.
+type WebhookResponse struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookDeliveryResponse represents an entry in a subscription's delivery log
+type WebhookDeliveryResponse struct {
+	ID             string     `json:"id"`
+	SubscriptionID string     `json:"subscription_id"`
+	EventType      string     `json:"event_type"`
+	TicketID       string     `json:"ticket_id"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// WebhookEvent is the signed JSON body POSTed to webhook endpoints
+type WebhookEvent struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	CreatedAt time.Time      `json:"created_at"`
+	Data      TicketResponse `json:"data"`
+}
+
+// GoogleWalletResponse holds the "Add to Google Wallet" link for a ticket
+type GoogleWalletResponse struct {
+	SaveURL string `json:"save_url"`
+}
+
+// CalendarFeedResponse holds the secret URL of a user's calendar feed
+type CalendarFeedResponse struct {
+	URL string `json:"url"`
+}
+
+// DeadLetterMessage represents a message in a dead-letter queue. The body is
+// only included when a single message is requested.
+type DeadLetterMessage struct {
+	MessageID      string          `json:"message_id"`
+	Type           string          `json:"type,omitempty"`
+	CorrelationID  string          `json:"correlation_id,omitempty"`
+	Source         string          `json:"source,omitempty"`
+	Queue          string          `json:"queue"`
+	Reason         string          `json:"reason"`
+	LastError      string          `json:"last_error,omitempty"`
+	RetryCount     int             `json:"retry_count"`
+	PublishedAt    time.Time       `json:"published_at"`
+	DeadLetteredAt *time.Time      `json:"dead_lettered_at,omitempty"`
+	Body           json.RawMessage `json:"body,omitempty"`
+}
+
+// ReplayDeadLettersRequest selects dead-lettered messages to move back onto their queue
+type ReplayDeadLettersRequest struct {
+	MessageIDs []string `json:"message_ids" binding:"required,min=1"`
+}
+
+// PurgeDeadLettersRequest selects dead-lettered messages to delete. Purging
+// the whole queue has to be asked for explicitly with all.
+type PurgeDeadLettersRequest struct {
+	MessageIDs []string `json:"message_ids"`
+	All        bool     `json:"all"`
+}
+
+// DeadLettersResponse reports how many dead-lettered messages were replayed or purged
+type DeadLettersResponse struct {
+	Count int `json:"count"`
+}
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
 	Error   string `json:"error"`